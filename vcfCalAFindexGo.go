@@ -8,6 +8,7 @@ import (
 	"math"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,6 +19,24 @@ var (
 	failFile = flag.String("fail", "", "Output file with failed index")
 	passFile = flag.String("pass", "", "Output file with passed index")
 	skipFile = flag.String("skip", "", "Output file with skiped index")
+	hetFile  = flag.String("het", "", "Output file with sites showing parental heterozygosity")
+	mode     = flag.String("mode", "parents", "Polarization mode: parents, single (one reference parent) or bulk (no parent)")
+	refPa    = flag.String("refParent", "v3", "Reference parent used in single mode: v3 or CE")
+	ems      = flag.Bool("ems", false, "Fail sites which are not canonical EMS transitions (G>A, C>T)")
+	emsPa    = flag.String("emsParent", "v3", "Non-mutagenized parent used in EMS filter: v3 or CE")
+	tests    = flag.Bool("tests", false, "Add Fisher exact, chi-square and G-test p-values of bulk allele counts")
+	post     = flag.Bool("post", false, "Add posterior mean and 95% credible interval of bulk allele frequencies")
+	popPrior = flag.String("p", "F2", "Population struction of beta prior in posterior: RIL or F2")
+	numPrior = flag.Int("n", 0, "Number of individuals in each bulk of beta prior, default 0 (uniform prior)")
+	useGT    = flag.Bool("gt", false, "Select parental polymorphisms by homozygous opposite GT calls of parents instead of index difference, not for bulk mode or samples combined by preprocess -groups")
+	minGQ    = flag.Int("minGQ", 20, "Minimum GQ of parental GT calls, ignored if FORMAT has no GQ")
+	minDP    = flag.Int("minDP", 10, "Minimum depth of parental GT calls")
+	ploidy   = flag.Int("ploidy", 2, "Ploidy of the population, e.g. 2, 4 or 6")
+	inherit  = flag.String("inherit", "poly", "Inheritance of polyploids: poly (polysomic) or di (disomic)")
+	homeo    = flag.Bool("homeo", false, "Reads of homeologous subgenomes map to SNVs of disomic polyploids (ref allele)")
+	biasMode = flag.String("bias", "", "Estimate and correct reference bias of bulk index: genome or chr (per chromosome), default off. Keep DpSim -bias at 0.5 for corrected index")
+	maskFile = flag.String("mask", "", "Input bed file of masked regions, SNVs inside are written to skip file")
+	postDiff = flag.Float64("postDelta", 0.1, "Allele frequency difference of bulks in posterior probability that bulks differ")
 )
 
 // getSNVlong function reads lines from vcf file and return a slice type
@@ -58,7 +77,7 @@ func writeSNVlong(SNVlong []string, path string) error {
 //function of calculate index
 func calculateSnvIndex(valLine string) float64 {
 	var sampleIndex float64 = 0.0
-	if valLine != "." {
+	if valLine != "." && valLine != "./." {
 		genoSlice := strings.Split(valLine, ":")
 		dpSlice := strings.Split(genoSlice[2], ",")
 		sampleRef, err := strconv.ParseFloat(dpSlice[0], 64)
@@ -98,10 +117,485 @@ func getSnvIndex(lineSlice []string) []float64 {
 	return result
 }
 
+// getGenoCols function will return the sample columns which must be called
+// under the given polarization mode
+func getGenoCols(mode string, refParent string) []int {
+	switch mode {
+	case "bulk":
+		return []int{11, 12}
+	case "single":
+		if refParent == "CE" {
+			return []int{10, 11, 12}
+		}
+		return []int{9, 11, 12}
+	default:
+		return []int{9, 10, 11, 12}
+	}
+}
+
+// isGenoMissing function will check whether any of the given sample columns
+// is not called
+func isGenoMissing(lineSlice []string, genoCols []int) bool {
+	for _, valCol := range genoCols {
+		if lineSlice[valCol] == "./." {
+			return true
+		}
+	}
+	return false
+}
+
+// getParentIndex function will return delta index of parents (v3 - CE). In
+// single mode the missing parent is assumed to be homozygous for the opposite
+// allele of the reference parent, in bulk mode there is no parent and the alt
+// allele is used for polarization
+func getParentIndex(vcfIndex []float64, mode string, refParent string) float64 {
+	switch mode {
+	case "bulk":
+		return 0.0
+	case "single":
+		if refParent == "CE" {
+			return (1.0 - vcfIndex[1]) - vcfIndex[1]
+		}
+		return vcfIndex[0] - (1.0 - vcfIndex[0])
+	default:
+		return vcfIndex[0] - vcfIndex[1]
+	}
+}
+
+// isParentHet function will check whether a site shows evidence of parental
+// heterozygosity. In single mode the reference parent itself is tested, in
+// bulk mode both bulks departing from 0.5 in the same direction indicates a
+// segregating allele carried by only one chromosome of a parent
+func isParentHet(vcfIndex []float64, mode string, refParent string) bool {
+	switch mode {
+	case "bulk":
+		return (vcfIndex[2] < 0.3 && vcfIndex[3] < 0.3) || (vcfIndex[2] > 0.7 && vcfIndex[3] > 0.7)
+	case "single":
+		paIndex := vcfIndex[0]
+		if refParent == "CE" {
+			paIndex = vcfIndex[1]
+		}
+		return paIndex >= 0.2 && paIndex <= 0.8
+	default:
+		return false
+	}
+}
+
+// isEMSsite function will check whether a site is a canonical EMS transition
+// (G>A or C>T) without alt reads in the non-mutagenized parent
+func isEMSsite(lineSlice []string, emsParent string) bool {
+	if !(lineSlice[3] == "G" && lineSlice[4] == "A") && !(lineSlice[3] == "C" && lineSlice[4] == "T") {
+		return false
+	}
+	paGeno := lineSlice[9]
+	if emsParent == "CE" {
+		paGeno = lineSlice[10]
+	}
+	return calculateSnvIndex(paGeno) == 0.0
+}
+
+// getADcount function will get ref and alt read counts of a sample
+func getADcount(genoField string) (float64, float64) {
+	if genoField == "." || genoField == "./." {
+		return 0.0, 0.0
+	}
+	genoSlice := strings.Split(genoField, ":")
+	dpSlice := strings.Split(genoSlice[2], ",")
+	if len(dpSlice) < 2 {
+		return 0.0, 0.0
+	}
+	refCount, _ := strconv.ParseFloat(dpSlice[0], 64)
+	altCount, _ := strconv.ParseFloat(dpSlice[1], 64)
+	return refCount, altCount
+}
+
+// logHyperProb function will calculate log probability of a 2x2 table with
+// given first cell under fixed margins (hypergeometric distribution)
+func logHyperProb(a float64, row1 float64, col1 float64, total float64) float64 {
+	lgFact := func(n float64) float64 {
+		val, _ := math.Lgamma(n + 1.0)
+		return val
+	}
+	b := row1 - a
+	c := col1 - a
+	d := total - row1 - col1 + a
+	return lgFact(row1) + lgFact(total-row1) + lgFact(col1) + lgFact(total-col1) -
+		lgFact(total) - lgFact(a) - lgFact(b) - lgFact(c) - lgFact(d)
+}
+
+// calBulkTests function will calculate two-sided Fisher exact, chi-square and
+// G-test p-values of the 2x2 table of ref and alt read counts in WT and MT
+// bulks, p-values are 1 if any margin of the table is zero
+func calBulkTests(wtGeno string, mtGeno string) []float64 {
+	wtRef, wtAlt := getADcount(wtGeno)
+	mtRef, mtAlt := getADcount(mtGeno)
+	obsSlice := []float64{wtAlt, wtRef, mtAlt, mtRef}
+	row1 := wtAlt + wtRef
+	row2 := mtAlt + mtRef
+	col1 := wtAlt + mtAlt
+	col2 := wtRef + mtRef
+	total := row1 + row2
+	if row1 == 0.0 || row2 == 0.0 || col1 == 0.0 || col2 == 0.0 {
+		return []float64{1.0, 1.0, 1.0}
+	}
+
+	//Fisher exact test, sum of tables not more probable than observed
+	obsLogProb := logHyperProb(wtAlt, row1, col1, total)
+	fisherP := 0.0
+	for a := math.Max(0.0, row1+col1-total); a <= math.Min(row1, col1); a++ {
+		logProb := logHyperProb(a, row1, col1, total)
+		if logProb <= obsLogProb+1e-7 {
+			fisherP += math.Exp(logProb)
+		}
+	}
+	if fisherP > 1.0 {
+		fisherP = 1.0
+	}
+
+	//chi-square and G statistics with one degree of freedom
+	expSlice := []float64{row1 * col1 / total, row1 * col2 / total, row2 * col1 / total, row2 * col2 / total}
+	chiSq := 0.0
+	gStat := 0.0
+	for i, valObs := range obsSlice {
+		chiSq += (valObs - expSlice[i]) * (valObs - expSlice[i]) / expSlice[i]
+		if valObs > 0.0 {
+			gStat += 2.0 * valObs * math.Log(valObs/expSlice[i])
+		}
+	}
+	chiSqP := math.Erfc(math.Sqrt(chiSq / 2.0))
+	gTestP := math.Erfc(math.Sqrt(math.Max(gStat, 0.0) / 2.0))
+	return []float64{fisherP, chiSqP, gTestP}
+}
+
+// getPriorShape function will get shape of the symmetric beta prior of bulk
+// allele frequency, whose variance matches that of a bulk of numIndvl
+// individuals without QTL (1/8n for F2 and 1/4n for RIL)
+func getPriorShape(popStrut string, numIndvl int) float64 {
+	priorShape := 1.0
+	switch {
+	case numIndvl <= 0:
+	case popStrut == "RIL":
+		priorShape = (float64(numIndvl) - 1.0) / 2.0
+	default:
+		priorShape = (2.0*float64(numIndvl) - 1.0) / 2.0
+	}
+	if priorShape < 1.0 {
+		priorShape = 1.0
+	}
+	return priorShape
+}
+
+// getPostGrid function will calculate beta posterior of allele frequency on
+// a grid of frequencies (grid integration) given ref and alt read counts
+func getPostGrid(refCount float64, altCount float64, priorShape float64, numGrid int) []float64 {
+	postSlice := make([]float64, numGrid)
+	maxLogPost := math.Inf(-1)
+	for i := range postSlice {
+		gridFreq := (float64(i) + 0.5) / float64(numGrid)
+		postSlice[i] = (altCount+priorShape-1.0)*math.Log(gridFreq) + (refCount+priorShape-1.0)*math.Log(1.0-gridFreq)
+		if postSlice[i] > maxLogPost {
+			maxLogPost = postSlice[i]
+		}
+	}
+	postSum := 0.0
+	for i := range postSlice {
+		postSlice[i] = math.Exp(postSlice[i] - maxLogPost)
+		postSum += postSlice[i]
+	}
+	for i := range postSlice {
+		postSlice[i] /= postSum
+	}
+	return postSlice
+}
+
+// getPostSummary function will get posterior mean and 95% credible interval
+// from posterior on grid
+func getPostSummary(postSlice []float64) []float64 {
+	numGrid := len(postSlice)
+	postMean := 0.0
+	postLow := 0.0
+	postHigh := 1.0
+	cumPost := 0.0
+	for i, valPost := range postSlice {
+		gridFreq := (float64(i) + 0.5) / float64(numGrid)
+		postMean += gridFreq * valPost
+		if cumPost < 0.025 && cumPost+valPost >= 0.025 {
+			postLow = gridFreq
+		}
+		if cumPost < 0.975 && cumPost+valPost >= 0.975 {
+			postHigh = gridFreq
+		}
+		cumPost += valPost
+	}
+	return []float64{postMean, postLow, postHigh}
+}
+
+// calBulkPost function will calculate posterior allele frequencies of WT and
+// MT bulks, and posterior probability that the bulks differ by more than
+// postDelta in allele frequency
+func calBulkPost(wtGeno string, mtGeno string, priorShape float64, postDelta float64) []float64 {
+	numGrid := 200
+	wtRef, wtAlt := getADcount(wtGeno)
+	mtRef, mtAlt := getADcount(mtGeno)
+	wtPost := getPostGrid(wtRef, wtAlt, priorShape, numGrid)
+	mtPost := getPostGrid(mtRef, mtAlt, priorShape, numGrid)
+
+	//cumulative posterior of MT bulk
+	mtCumPost := make([]float64, numGrid+1)
+	for j, valPost := range mtPost {
+		mtCumPost[j+1] = mtCumPost[j] + valPost
+	}
+	numDelta := int(math.Round(postDelta * float64(numGrid)))
+	diffProb := 0.0
+	for i, valPost := range wtPost {
+		lowIdx := i - numDelta
+		highIdx := i + numDelta + 1
+		if lowIdx > 0 {
+			diffProb += valPost * mtCumPost[lowIdx]
+		}
+		if highIdx < numGrid {
+			diffProb += valPost * (1.0 - mtCumPost[highIdx])
+		}
+	}
+
+	postSlice := getPostSummary(wtPost)
+	postSlice = append(postSlice, getPostSummary(mtPost)...)
+	postSlice = append(postSlice, diffProb)
+	return postSlice
+}
+
+// getBackgroundIndex function will return expected index of unlinked SNVs
+// given ploidy and inheritance, as in the simulation of null model
+func getBackgroundIndex(ploidy int, inherit string, homeo bool) float64 {
+	if ploidy > 2 && inherit == "di" && homeo {
+		return 1.0 / float64(ploidy)
+	}
+	return 0.5
+}
+
+// getParentGT function will classify GT call of a parent as hom_ref,
+// hom_alt, het or low (missing call, or GQ or depth below minimum). Missing
+// GQ (".") is ignored as FORMAT without GQ
+func getParentGT(genoField string, formatField string, minGQ int, minDP int) string {
+	if genoField == "." || genoField == "./." {
+		return "low"
+	}
+	genoSlice := strings.Split(genoField, ":")
+	gtIdx := -1
+	gqIdx := -1
+	for idxFormat, valFormat := range strings.Split(formatField, ":") {
+		switch valFormat {
+		case "GT":
+			gtIdx = idxFormat
+		case "GQ":
+			gqIdx = idxFormat
+		}
+	}
+	if gtIdx < 0 || gtIdx >= len(genoSlice) {
+		return "low"
+	}
+	if gqIdx >= 0 && gqIdx < len(genoSlice) && genoSlice[gqIdx] != "." && genoSlice[gqIdx] != "" {
+		gq, err := strconv.ParseFloat(genoSlice[gqIdx], 64)
+		if err != nil || gq < float64(minGQ) {
+			return "low"
+		}
+	}
+	refCount, altCount := getADcount(genoField)
+	if refCount+altCount < float64(minDP) {
+		return "low"
+	}
+
+	alleleSlice := strings.FieldsFunc(genoSlice[gtIdx], func(c rune) bool { return c == '/' || c == '|' })
+	numAlt := 0
+	for _, valAllele := range alleleSlice {
+		switch valAllele {
+		case ".":
+			return "low"
+		case "0":
+		default:
+			numAlt++
+		}
+	}
+	switch {
+	case len(alleleSlice) == 0:
+		return "low"
+	case numAlt == 0:
+		return "hom_ref"
+	case numAlt == len(alleleSlice):
+		return "hom_alt"
+	default:
+		return "het"
+	}
+}
+
+// getParentGTstatus function will check parental GT calls of a site and
+// return het if any required parent is called heterozygous, pass if parents
+// are homozygous for opposite alleles (or the reference parent is homozygous
+// in single mode), otherwise fail
+func getParentGTstatus(lineSlice []string, mode string, refParent string, minGQ int, minDP int) string {
+	v3GT := getParentGT(lineSlice[9], lineSlice[8], minGQ, minDP)
+	ceGT := getParentGT(lineSlice[10], lineSlice[8], minGQ, minDP)
+	if mode == "single" {
+		paGT := v3GT
+		if refParent == "CE" {
+			paGT = ceGT
+		}
+		switch paGT {
+		case "het":
+			return "het"
+		case "hom_ref", "hom_alt":
+			return "pass"
+		default:
+			return "fail"
+		}
+	}
+	switch {
+	case v3GT == "het" || ceGT == "het":
+		return "het"
+	case (v3GT == "hom_ref" && ceGT == "hom_alt") || (v3GT == "hom_alt" && ceGT == "hom_ref"):
+		return "pass"
+	default:
+		return "fail"
+	}
+}
+
+// estimateBias function will estimate background alt read ratio of bulks as
+// median of 1 Mb block means (at least 10 SNVs each) of the two bulks, which
+// is robust to the few blocks linked to QTLs. Only sites with both bulks
+// called and, with parents, sites polymorphic between parents (|delta index|
+// > minParent) are used, and masked sites are ignored. Estimates are keyed by
+// chromosome in chr mode, otherwise by "genome"
+func estimateBias(vcfLines []string, mode string, refParent string, biasMode string, maskMap map[string][][]int, minParent float64) map[string]float64 {
+	blockSumMap := map[string]float64{}
+	blockNumMap := map[string]int{}
+	for _, valSNV := range vcfLines {
+		snvSlice := strings.Fields(valSNV)
+		snvPos, _ := strconv.Atoi(snvSlice[1])
+		if isGenoMissing(snvSlice, getGenoCols(mode, refParent)) || (maskMap != nil && isMasked(maskMap, snvSlice[0], snvPos)) {
+			continue
+		}
+		vcfIndex := getSnvIndex(snvSlice)
+		if mode != "bulk" && math.Abs(getParentIndex(vcfIndex, mode, refParent)) <= minParent {
+			continue
+		}
+		blockKey := snvSlice[0] + "_" + strconv.Itoa(snvPos/1000000)
+		blockSumMap[blockKey] += (vcfIndex[2] + vcfIndex[3]) / 2.0
+		blockNumMap[blockKey]++
+	}
+
+	blockAveMap := map[string][]float64{}
+	for keyBlock, valSum := range blockSumMap {
+		if blockNumMap[keyBlock] < 10 {
+			continue
+		}
+		biasKey := "genome"
+		if biasMode == "chr" {
+			biasKey = strings.Split(keyBlock, "_")[0]
+		}
+		blockAveMap[biasKey] = append(blockAveMap[biasKey], valSum/float64(blockNumMap[keyBlock]))
+	}
+
+	biasMap := map[string]float64{}
+	for keyBias, valAve := range blockAveMap {
+		sort.Float64s(valAve)
+		numBlock := len(valAve)
+		if numBlock%2 == 1 {
+			biasMap[keyBias] = valAve[numBlock/2]
+		} else {
+			biasMap[keyBias] = (valAve[numBlock/2-1] + valAve[numBlock/2]) / 2.0
+		}
+	}
+	return biasMap
+}
+
+// correctIndex function will correct index of a sample by relative mapping
+// efficiency of alt reads w = bias/(1-bias), index = alt/(alt + w*ref)
+func correctIndex(genoField string, bias float64) float64 {
+	refCount, altCount := getADcount(genoField)
+	biasWeight := bias / (1.0 - bias)
+	if altCount+biasWeight*refCount == 0.0 {
+		return 0.0
+	}
+	return altCount / (altCount + biasWeight*refCount)
+}
+
+// getMaskMap function will read mask bed file and return a map type, with
+// chromosome as key, and sorted merged 1-based closed intervals as value
+func getMaskMap(path string) (map[string][][]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^(#|track|browser)")
+	maskMap := map[string][][]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineSlice := strings.Fields(line)
+		if header.MatchString(line) || len(lineSlice) < 3 {
+			continue
+		}
+		startPos, err := strconv.Atoi(lineSlice[1])
+		if err != nil {
+			return nil, err
+		}
+		endPos, err := strconv.Atoi(lineSlice[2])
+		if err != nil {
+			return nil, err
+		}
+		maskMap[lineSlice[0]] = append(maskMap[lineSlice[0]], []int{startPos + 1, endPos})
+	}
+
+	for keyChr, valMask := range maskMap {
+		sort.Slice(valMask, func(a, b int) bool { return valMask[a][0] < valMask[b][0] })
+		mergedMask := [][]int{}
+		for _, valRegion := range valMask {
+			lastIdx := len(mergedMask) - 1
+			if lastIdx >= 0 && valRegion[0] <= mergedMask[lastIdx][1]+1 {
+				if valRegion[1] > mergedMask[lastIdx][1] {
+					mergedMask[lastIdx][1] = valRegion[1]
+				}
+			} else {
+				mergedMask = append(mergedMask, []int{valRegion[0], valRegion[1]})
+			}
+		}
+		maskMap[keyChr] = mergedMask
+	}
+	return maskMap, scanner.Err()
+}
+
+// isMasked function will check whether a position is inside masked regions
+func isMasked(maskMap map[string][][]int, chr string, pos int) bool {
+	chrMask := maskMap[chr]
+	maskIdx := sort.Search(len(chrMask), func(i int) bool { return chrMask[i][1] >= pos })
+	return maskIdx < len(chrMask) && chrMask[maskIdx][0] <= pos
+}
+
 // main function
 func main() {
 	flag.Parse()
 
+	if *mode != "parents" && *mode != "single" && *mode != "bulk" {
+		log.Fatalf("unknown -mode %s: parents, single or bulk", *mode)
+	}
+	if *refPa != "v3" && *refPa != "CE" {
+		log.Fatalf("unknown -refParent %s: v3 or CE", *refPa)
+	}
+	if *useGT && *mode == "bulk" {
+		log.Fatalf("-gt needs parental GT calls, which are not used in bulk mode")
+	}
+	if *ploidy < 2 || *ploidy%2 != 0 {
+		log.Fatalf("ploidy %d is not an even number of at least 2", *ploidy)
+	}
+	if *inherit != "poly" && *inherit != "di" {
+		log.Fatalf("unknown -inherit %s: poly or di", *inherit)
+	}
+	if *biasMode != "" && getBackgroundIndex(*ploidy, *inherit, *homeo) != 0.5 {
+		log.Fatalf("-bias assumes background index 0.5, which is not the case with -inherit di -homeo")
+	}
+
 	vcfLines, err := getSNVlong(*vcfFile)
 	if err != nil {
 		log.Fatalf("read input vcf file: %s", err)
@@ -114,12 +608,60 @@ func main() {
 		"#ID", "geno_v3", "geno_CE", "geno_WT", "geno_MT",
 		"idx_v3", "idx_CE", "idx_WT", "idx_MT", "deltaIdx_Parent", "deltaIdx_F2",
 	}
+	if *tests {
+		indexHeader = append(indexHeader, "fisher_p", "chisq_p", "gtest_p")
+	}
+	if *post {
+		indexHeader = append(indexHeader, "post_WT", "post_WT_low", "post_WT_high",
+			"post_MT", "post_MT_low", "post_MT_high", "post_pdiff")
+	}
+	priorShape := getPriorShape(*popPrior, *numPrior)
 	snvPassSlice = append(snvPassSlice, strings.Join(indexHeader, "\t"))
 	snvFailSlice = append(snvFailSlice, strings.Join(indexHeader, "\t"))
 
+	snvHetSlice := []string{}
+	snvHetSlice = append(snvHetSlice, strings.Join(indexHeader, "\t"))
+
+	var maskMap map[string][][]int
+	if *maskFile != "" {
+		maskMap, err = getMaskMap(*maskFile)
+		if err != nil {
+			log.Fatalf("read mask bed file: %s", err)
+		}
+	}
+
+	//minimum bulk index and parental delta index are scaled with expected
+	//background index, as index of homozygous alt parents is diluted by
+	//homeologous reads
+	bgScale := getBackgroundIndex(*ploidy, *inherit, *homeo) / 0.5
+	minIndex := 0.3 * bgScale
+	minParent := 0.9 * bgScale
+	if *ploidy > 2 {
+		fmt.Println("Expected background index, minimum bulk index and minimum parental delta index is: ",
+			strconv.FormatFloat(getBackgroundIndex(*ploidy, *inherit, *homeo), 'f', 4, 64),
+			strconv.FormatFloat(minIndex, 'f', 4, 64), strconv.FormatFloat(minParent, 'f', 4, 64))
+	}
+
+	var biasMap map[string]float64
+	if *biasMode != "" {
+		biasMap = estimateBias(vcfLines, *mode, *refPa, *biasMode, maskMap, minParent)
+		biasKeySlice := []string{}
+		for keyBias := range biasMap {
+			biasKeySlice = append(biasKeySlice, keyBias)
+		}
+		sort.Strings(biasKeySlice)
+		for _, keyBias := range biasKeySlice {
+			fmt.Println("Estimated background alt read ratio of ", keyBias, " is: ", strconv.FormatFloat(biasMap[keyBias], 'f', 4, 64))
+		}
+	}
+
+	genoCols := getGenoCols(*mode, *refPa)
 	for _, valSNV := range vcfLines {
 		snvSlice := strings.Fields(valSNV)
-		if snvSlice[9] != "./." && snvSlice[10] != "./." && snvSlice[11] != "./." && snvSlice[12] != "./." {
+		snvPos, _ := strconv.Atoi(snvSlice[1])
+		if maskMap != nil && isMasked(maskMap, snvSlice[0], snvPos) {
+			snvSkipSlice = append(snvSkipSlice, valSNV)
+		} else if !isGenoMissing(snvSlice, genoCols) {
 			newIdSlice := []string{}
 			newSnvSlice := []string{}
 			newIdSlice = append(newIdSlice, snvSlice[0:2]...)
@@ -128,7 +670,17 @@ func main() {
 			newSnvSlice = append(newSnvSlice, snvSlice[9], snvSlice[10], snvSlice[11], snvSlice[12])
 
 			vcfIndex := getSnvIndex(snvSlice)
-			vcfIndexParent := vcfIndex[0] - vcfIndex[1]
+			if biasMap != nil {
+				biasKey := "genome"
+				if *biasMode == "chr" {
+					biasKey = snvSlice[0]
+				}
+				if bias, ok := biasMap[biasKey]; ok && bias > 0.0 && bias < 1.0 {
+					vcfIndex[2] = correctIndex(snvSlice[11], bias)
+					vcfIndex[3] = correctIndex(snvSlice[12], bias)
+				}
+			}
+			vcfIndexParent := getParentIndex(vcfIndex, *mode, *refPa)
 			vcfIndexF2 := 0.0
 			if vcfIndexParent < 0.0 {
 				vcfIndexF2 = (vcfIndex[2] - vcfIndex[3]) * -1.0
@@ -141,14 +693,46 @@ func main() {
 				vcfIndexStrSlice = append(vcfIndexStrSlice, strconv.FormatFloat(valVcfIndex, 'f', 2, 64))
 			}
 			newSnvSlice = append(newSnvSlice, vcfIndexStrSlice...)
+			if *tests {
+				for _, valTest := range calBulkTests(snvSlice[11], snvSlice[12]) {
+					newSnvSlice = append(newSnvSlice, strconv.FormatFloat(valTest, 'g', 4, 64))
+				}
+			}
+			if *post {
+				for _, valPost := range calBulkPost(snvSlice[11], snvSlice[12], priorShape, *postDiff) {
+					newSnvSlice = append(newSnvSlice, strconv.FormatFloat(valPost, 'f', 3, 64))
+				}
+			}
 
-			if math.Abs(vcfIndexParent) > 0.9 {
-				if vcfIndex[2] < 0.3 && vcfIndex[3] < 0.3 {
+			gtStatus := ""
+			if *useGT {
+				gtStatus = getParentGTstatus(snvSlice, *mode, *refPa, *minGQ, *minDP)
+			}
+
+			switch {
+			case *ems && !isEMSsite(snvSlice, *emsPa):
+				snvFailSlice = append(snvFailSlice, strings.Join(newSnvSlice, "\t"))
+			case gtStatus == "het":
+				snvHetSlice = append(snvHetSlice, strings.Join(newSnvSlice, "\t"))
+			case gtStatus == "fail":
+				snvFailSlice = append(snvFailSlice, strings.Join(newSnvSlice, "\t"))
+			case gtStatus == "pass":
+				if vcfIndex[2] < minIndex && vcfIndex[3] < minIndex {
 					snvFailSlice = append(snvFailSlice, strings.Join(newSnvSlice, "\t"))
 				} else {
 					snvPassSlice = append(snvPassSlice, strings.Join(newSnvSlice, "\t"))
 				}
-			} else {
+			case isParentHet(vcfIndex, *mode, *refPa):
+				snvHetSlice = append(snvHetSlice, strings.Join(newSnvSlice, "\t"))
+			case *mode == "bulk":
+				snvPassSlice = append(snvPassSlice, strings.Join(newSnvSlice, "\t"))
+			case math.Abs(vcfIndexParent) > minParent:
+				if vcfIndex[2] < minIndex && vcfIndex[3] < minIndex {
+					snvFailSlice = append(snvFailSlice, strings.Join(newSnvSlice, "\t"))
+				} else {
+					snvPassSlice = append(snvPassSlice, strings.Join(newSnvSlice, "\t"))
+				}
+			default:
 				snvFailSlice = append(snvFailSlice, strings.Join(newSnvSlice, "\t"))
 			}
 		} else {
@@ -156,6 +740,9 @@ func main() {
 		}
 	}
 
+	fmt.Println("Total number of pass, fail, parental het and skip SNVs is: ",
+		len(snvPassSlice)-1, len(snvFailSlice)-1, len(snvHetSlice)-1, len(snvSkipSlice))
+
 	//write skip snv file
 	if err := writeSNVlong(snvSkipSlice, *skipFile); err != nil {
 		log.Fatalf("write skip vcf: $s", err)
@@ -171,4 +758,11 @@ func main() {
 		log.Fatalf("write fail vcf: $s", err)
 	}
 
+	// Write het snv file
+	if *hetFile != "" {
+		if err := writeSNVlong(snvHetSlice, *hetFile); err != nil {
+			log.Fatalf("write het vcf: %s", err)
+		}
+	}
+
 }
@@ -0,0 +1,356 @@
+// This tool is used to calculate SNP index of replicated bulk pairs, with
+// two parents (v3, CE) followed by N pairs of high and low bulks in a vcf
+// file. A Cochran-Mantel-Haenszel test is done for each SNV across replicates
+// and delta SNP index is combined in sliding windows.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// declare command arguments
+var (
+	vcfFile    = flag.String("in", "", "Input vcf file with parents and N bulk pairs (high, low)")
+	chrFile    = flag.String("chr", "", "Input chromosome file")
+	snvFile    = flag.String("snv", "", "Output file with index and CMH test of each SNV")
+	outFile    = flag.String("out", "", "Output file with index in sliding windows")
+	numPairs   = flag.Int("pairs", 2, "Number of replicated bulk pairs")
+	minDp      = flag.Int("minDP", 10, "Minimum depth of each sample")
+	cpus       = flag.Int("c", 1, "Number of working CPUs")
+	windowSize = flag.Int("w", 20000000, "Window size, default (20mb) as in sliding windows stage")
+	shiftSize  = flag.Int("s", 20000, "Shift size, default (20kb)")
+)
+
+// getLines function reads lines from file and return a slice,
+// with each element representing each line
+func getLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^[^#]")
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// writeLines function will write lines in a slice in to out file
+func writeLines(lines []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// getADcount function will return ref and alt read count of a sample
+func getADcount(genoField string) (float64, float64) {
+	if genoField == "." || genoField == "./." {
+		return 0.0, 0.0
+	}
+	genoSlice := strings.Split(genoField, ":")
+	adSlice := strings.Split(genoSlice[2], ",")
+	refCount, _ := strconv.ParseFloat(adSlice[0], 64)
+	altCount, _ := strconv.ParseFloat(adSlice[1], 64)
+	return refCount, altCount
+}
+
+// calculateCMH function will do Cochran-Mantel-Haenszel test with continuity
+// correction on 2x2 tables (bulk x allele) of all replicates, and return the
+// statistic and p value
+func calculateCMH(tableSlice [][]float64) (float64, float64) {
+	sumDiff := 0.0
+	sumVar := 0.0
+	for _, valTable := range tableSlice {
+		a, b, c, d := valTable[0], valTable[1], valTable[2], valTable[3]
+		n := a + b + c + d
+		if n < 2 {
+			continue
+		}
+		sumDiff += a - (a+b)*(a+c)/n
+		sumVar += (a + b) * (c + d) * (a + c) * (b + d) / (n * n * (n - 1))
+	}
+	if sumVar == 0.0 {
+		return 0.0, 1.0
+	}
+	cmhStat := math.Pow(math.Max(math.Abs(sumDiff)-0.5, 0.0), 2) / sumVar
+	cmhP := math.Erfc(math.Sqrt(cmhStat / 2.0))
+	return cmhStat, cmhP
+}
+
+// getSnvIndex function will calculate polarized delta SNP index of each bulk
+// pair and CMH test, the allele of parent v3 is used for polarization.
+// An empty string is returned if the site is not informative
+func getSnvIndex(lineSlice []string, numPairs int, minDp int) string {
+	v3Ref, v3Alt := getADcount(lineSlice[9])
+	ceRef, ceAlt := getADcount(lineSlice[10])
+	if v3Ref+v3Alt < float64(minDp) || ceRef+ceAlt < float64(minDp) {
+		return ""
+	}
+	v3Index := v3Alt / (v3Ref + v3Alt)
+	ceIndex := ceAlt / (ceRef + ceAlt)
+	if math.Abs(v3Index-ceIndex) <= 0.9 {
+		return ""
+	}
+
+	deltaSlice := []float64{}
+	tableSlice := [][]float64{}
+	for i := 0; i < numPairs; i++ {
+		highRef, highAlt := getADcount(lineSlice[11+2*i])
+		lowRef, lowAlt := getADcount(lineSlice[12+2*i])
+		if highRef+highAlt < float64(minDp) || lowRef+lowAlt < float64(minDp) {
+			return ""
+		}
+		if v3Index < ceIndex {
+			highRef, highAlt = highAlt, highRef
+			lowRef, lowAlt = lowAlt, lowRef
+		}
+		deltaSlice = append(deltaSlice, highAlt/(highRef+highAlt)-lowAlt/(lowRef+lowAlt))
+		tableSlice = append(tableSlice, []float64{highAlt, highRef, lowAlt, lowRef})
+	}
+	cmhStat, cmhP := calculateCMH(tableSlice)
+
+	deltaSum := 0.0
+	snvSlice := []string{}
+	for _, valDelta := range deltaSlice {
+		deltaSum += valDelta
+		snvSlice = append(snvSlice, strconv.FormatFloat(valDelta, 'f', 2, 64))
+	}
+	snvSlice = append(snvSlice, strconv.FormatFloat(deltaSum/float64(numPairs), 'f', 2, 64))
+	snvSlice = append(snvSlice, strconv.FormatFloat(cmhStat, 'f', 2, 64))
+	snvSlice = append(snvSlice, strconv.FormatFloat(cmhP, 'e', 2, 64))
+	snvSlice = append(snvSlice, strconv.FormatFloat(-math.Log10(math.Max(cmhP, 1e-300)), 'f', 2, 64))
+	return strings.Join(snvSlice, "\t")
+}
+
+// makeSlidingWindows function will make sliding windows with window Size *w,
+// and shift size *s
+func makeSlidingWindows(chrLine []string, windowSize int, shiftSize int) []string {
+	slidingWindowSlice := []string{}
+	for _, valChrLine := range chrLine {
+		chrStrSlice := strings.Fields(valChrLine)
+		chrIntSlice := []int{}
+		for _, valChrStrSlice := range chrStrSlice {
+			intChrStrSlice, _ := strconv.Atoi(valChrStrSlice)
+			chrIntSlice = append(chrIntSlice, intChrStrSlice)
+		}
+		for i := 1; i <= chrIntSlice[1]; i += shiftSize {
+			startPos := i - windowSize/2
+			endPos := i + windowSize/2 - 1
+			if startPos < 1 {
+				startPos = 1
+			}
+			if endPos > chrIntSlice[1] {
+				endPos = chrIntSlice[1]
+			}
+			binIntSlice := []int{chrIntSlice[0], startPos, endPos, i}
+			binStrSlice := []string{}
+			for _, valBinVal := range binIntSlice {
+				binStrSlice = append(binStrSlice, strconv.Itoa(valBinVal))
+			}
+			slidingWindowSlice = append(slidingWindowSlice, strings.Join(binStrSlice, "\t"))
+		}
+	}
+	fmt.Println("The total number of sliding windows (", windowSize, " + ", shiftSize, ") is: ", len(slidingWindowSlice))
+	return slidingWindowSlice
+}
+
+// calculateAveIndex function will calculate average delta index of each
+// replicate and combined statistics in a window. Replicate consistency is the
+// fraction of replicates with the same direction as the combined delta index
+func calculateAveIndex(binLine []int, snvMap map[string]string, numPairs int) string {
+	deltaSumSlice := make([]float64, numPairs+1)
+	cmhSum := 0.0
+	logPSum := 0.0
+	logPMax := 0.0
+
+	numVcf := 0
+	for j := binLine[1]; j <= binLine[2]; j++ {
+		posKey := strconv.Itoa(binLine[0]) + "_" + strconv.Itoa(j)
+		if posVal, ok := snvMap[posKey]; ok {
+			posValSlice := strings.Fields(posVal)
+			for i := 0; i <= numPairs; i++ {
+				deltaVal, _ := strconv.ParseFloat(posValSlice[i], 64)
+				deltaSumSlice[i] += deltaVal
+			}
+			cmhVal, _ := strconv.ParseFloat(posValSlice[numPairs+1], 64)
+			logPVal, _ := strconv.ParseFloat(posValSlice[numPairs+3], 64)
+			cmhSum += cmhVal
+			logPSum += logPVal
+			if logPVal > logPMax {
+				logPMax = logPVal
+			}
+			numVcf++
+		}
+	}
+
+	indexSlice := []string{}
+	for _, valBin := range binLine {
+		indexSlice = append(indexSlice, strconv.Itoa(valBin))
+	}
+	indexSlice = append(indexSlice, strconv.Itoa(numVcf))
+
+	deltaAveSlice := make([]float64, numPairs+1)
+	cmhAve := 0.0
+	logPAve := 0.0
+	consistency := 0.0
+	if numVcf > 9 {
+		for i := range deltaSumSlice {
+			deltaAveSlice[i] = deltaSumSlice[i] / float64(numVcf)
+		}
+		cmhAve = cmhSum / float64(numVcf)
+		logPAve = logPSum / float64(numVcf)
+		numConsistent := 0
+		for i := 0; i < numPairs; i++ {
+			if deltaAveSlice[i]*deltaAveSlice[numPairs] > 0.0 {
+				numConsistent++
+			}
+		}
+		consistency = float64(numConsistent) / float64(numPairs)
+	} else {
+		logPMax = 0.0
+	}
+	for _, valDelta := range deltaAveSlice {
+		indexSlice = append(indexSlice, strconv.FormatFloat(valDelta, 'f', 2, 64))
+	}
+	indexSlice = append(indexSlice, strconv.FormatFloat(cmhAve, 'f', 2, 64))
+	indexSlice = append(indexSlice, strconv.FormatFloat(logPAve, 'f', 2, 64))
+	indexSlice = append(indexSlice, strconv.FormatFloat(logPMax, 'f', 2, 64))
+	indexSlice = append(indexSlice, strconv.FormatFloat(consistency, 'f', 2, 64))
+
+	return strings.Join(indexSlice, "\t")
+}
+
+//worker function for making worker pools
+func worker(snvMap map[string]string, numPairs int, jobs <-chan string, results chan<- string) {
+	for j := range jobs {
+		binSlice := strings.Fields(j)
+		chrPos, _ := strconv.Atoi(binSlice[0])
+		startPos, _ := strconv.Atoi(binSlice[1])
+		endPos, _ := strconv.Atoi(binSlice[2])
+		midPos, _ := strconv.Atoi(binSlice[3])
+		binPosSlice := []int{chrPos, startPos, endPos, midPos}
+
+		results <- calculateAveIndex(binPosSlice, snvMap, numPairs)
+	}
+}
+
+// maxParallelism function will return number of CPUs
+func maxParallelism(cpus int) int {
+	maxProcs := runtime.GOMAXPROCS(cpus)
+	numCPU := runtime.NumCPU()
+	if maxProcs < numCPU {
+		return maxProcs
+	}
+	return numCPU
+}
+
+// main function
+func main() {
+	flag.Parse()
+
+	fmt.Println("[", time.Now(), "] ", "Program start ...")
+	numThreads := maxParallelism(*cpus)
+	fmt.Println("Total available CPU number is: ", runtime.NumCPU())
+	fmt.Println("Working CPU number is: ", numThreads)
+
+	vcfLines, err := getLines(*vcfFile)
+	if err != nil {
+		log.Fatalf("read input vcf file: %s", err)
+	}
+
+	chrLines, err := getLines(*chrFile)
+	if err != nil {
+		log.Fatalf("read input chr file: %s", err)
+	}
+
+	//calculate index and CMH test of each SNV
+	snvHeader := []string{"#ID"}
+	for i := 1; i <= *numPairs; i++ {
+		snvHeader = append(snvHeader, "deltaIdx_rep"+strconv.Itoa(i))
+	}
+	snvHeader = append(snvHeader, "deltaIdx_mean", "CMH", "CMH_p", "CMH_log10p")
+
+	snvLines := []string{strings.Join(snvHeader, "\t")}
+	snvMap := map[string]string{}
+	for _, valLine := range vcfLines {
+		lineSlice := strings.Fields(valLine)
+		if len(lineSlice) < 11+2*(*numPairs) || strings.Contains(lineSlice[4], ",") {
+			continue
+		}
+		snvIndex := getSnvIndex(lineSlice, *numPairs, *minDp)
+		if snvIndex == "" {
+			continue
+		}
+		idSlice := []string{lineSlice[0], lineSlice[1], lineSlice[3], lineSlice[4]}
+		snvLines = append(snvLines, strings.Join(idSlice, "_")+"\t"+snvIndex)
+		snvMap[lineSlice[0]+"_"+lineSlice[1]] = snvIndex
+	}
+	fmt.Println("Total number of informative SNVs is: ", len(snvMap))
+
+	if err := writeLines(snvLines, *snvFile); err != nil {
+		log.Fatalf("write SNV index: %s", err)
+	}
+
+	binSlice := makeSlidingWindows(chrLines, *windowSize, *shiftSize)
+
+	//start worker
+	jobs := make(chan string, len(binSlice))
+	results := make(chan string, len(binSlice))
+	for w := 1; w <= numThreads; w++ {
+		go worker(snvMap, *numPairs, jobs, results)
+	}
+
+	for _, valBinSlice := range binSlice {
+		jobs <- valBinSlice
+	}
+	close(jobs)
+
+	mapBinLines := map[string]string{}
+	for a := 1; a <= len(binSlice); a++ {
+		valBinLine := <-results
+		valBinLineSlice := strings.Fields(valBinLine)
+		mapBinLines[strings.Join(valBinLineSlice[:4], "\t")] = valBinLine
+	}
+
+	binHeader := []string{"#CHR", "START", "END", "mid_pos", "num_SNVs"}
+	for i := 1; i <= *numPairs; i++ {
+		binHeader = append(binHeader, "AveIdx_rep"+strconv.Itoa(i))
+	}
+	binHeader = append(binHeader, "AveIdx_mean", "Ave_CMH", "Ave_log10p", "Max_log10p", "rep_consistency")
+
+	newBinLines := []string{strings.Join(binHeader, "\t")}
+	for _, valBinSlice := range binSlice {
+		newBinLines = append(newBinLines, mapBinLines[valBinSlice])
+	}
+
+	if err := writeLines(newBinLines, *outFile); err != nil {
+		log.Fatalf("write sliding windows: %s", err)
+	}
+
+	fmt.Println("[", time.Now(), "] ", "Program end ...")
+}
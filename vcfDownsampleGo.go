@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// declare command arguments
+var (
+	vcfFile    = flag.String("in", "", "Input vcf file")
+	outFile    = flag.String("out", "", "Output downsampled vcf file")
+	sampleList = flag.String("samples", "WT,MT", "Comma separated sample names (as in #CHROM title) to downsample")
+	targetDp   = flag.Int("dp", 0, "Target depth, sites above are downsampled to this depth, default 0 (off)")
+	targetRat  = flag.Float64("ratio", 0.0, "Fraction of reads kept at each site, default 0 (off)")
+	matchDp    = flag.Bool("match", false, "Downsample selected samples to the smallest depth among them at each site")
+	minDp      = flag.Int("minDP", 10, "Minimum depth of each selected sample after downsampling, sites below are removed as in vcfPreProcess, 0 (off)")
+	seed       = flag.Int64("seed", 1, "Random seed")
+)
+
+// getVcfLines function reads all lines (header and SNVs) from vcf file and
+// return a slice type
+func getVcfLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// writeLines function will write lines in a slice in to out file
+func writeLines(lines []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// getSampleCols function will get columns of selected samples from #CHROM
+// title line
+func getSampleCols(titleLine string, sampleList string) ([]int, error) {
+	titleSlice := strings.Fields(titleLine)
+	sampleCols := []int{}
+	for _, valSample := range strings.Split(sampleList, ",") {
+		sampleCol := -1
+		for idxTitle := 9; idxTitle < len(titleSlice); idxTitle++ {
+			if titleSlice[idxTitle] == strings.TrimSpace(valSample) {
+				sampleCol = idxTitle
+			}
+		}
+		if sampleCol < 0 {
+			return nil, fmt.Errorf("sample %s is not in vcf title", valSample)
+		}
+		sampleCols = append(sampleCols, sampleCol)
+	}
+	return sampleCols, nil
+}
+
+// getFormatIdx function will get index of each FORMAT field, -1 if missing
+func getFormatIdx(formatField string) map[string]int {
+	formatIdx := map[string]int{"GT": -1, "DP": -1, "AD": -1, "RO": -1, "QR": -1, "AO": -1, "QA": -1}
+	for idxFormat, valFormat := range strings.Split(formatField, ":") {
+		if _, ok := formatIdx[valFormat]; ok {
+			formatIdx[valFormat] = idxFormat
+		}
+	}
+	return formatIdx
+}
+
+// getADcount function will get read counts of ref and all alt alleles of a
+// sample, nil if AD is missing
+func getADcount(genoField string, formatIdx map[string]int) []int {
+	genoSlice := strings.Split(genoField, ":")
+	if genoField == "." || genoField == "./." || formatIdx["AD"] < 0 || formatIdx["AD"] >= len(genoSlice) {
+		return nil
+	}
+	adSlice := strings.Split(genoSlice[formatIdx["AD"]], ",")
+	if len(adSlice) < 2 {
+		return nil
+	}
+	countSlice := []int{}
+	for _, valAD := range adSlice {
+		adCount, _ := strconv.Atoi(valAD)
+		countSlice = append(countSlice, adCount)
+	}
+	return countSlice
+}
+
+// sampleHyper function will draw numDraw reads without replacement from reads
+// of all alleles (multivariate hypergeometric), and return the number of reads
+// drawn of each allele
+func sampleHyper(r *rand.Rand, countSlice []int, numDraw int) []int {
+	drawSlice := make([]int, len(countSlice))
+	remainSlice := append([]int{}, countSlice...)
+	remainTotal := 0
+	for _, valCount := range countSlice {
+		remainTotal += valCount
+	}
+	for i := 0; i < numDraw && remainTotal > 0; i++ {
+		readIdx := r.Intn(remainTotal)
+		for idxAllele, valRemain := range remainSlice {
+			if readIdx < valRemain {
+				drawSlice[idxAllele]++
+				remainSlice[idxAllele]--
+				break
+			}
+			readIdx -= valRemain
+		}
+		remainTotal--
+	}
+	return drawSlice
+}
+
+// downsampleGeno function will replace read counts of a geno field by
+// downsampled counts of all alleles, qualities are scaled by fraction of reads
+// kept of each allele and genotype is called from ref and summed alt read
+// counts as in vcfPreProcess. Samples without reads left are not called (./.)
+func downsampleGeno(genoField string, formatIdx map[string]int, countSlice []int, newCountSlice []int) string {
+	genoSlice := strings.Split(genoField, ":")
+	scaleQual := func(oldQual string, oldCount int, newCount int) string {
+		oldQualInt, err := strconv.Atoi(oldQual)
+		if err != nil || oldCount == 0 {
+			return oldQual
+		}
+		return strconv.Itoa(int(math.Round(float64(oldQualInt) * float64(newCount) / float64(oldCount))))
+	}
+	if qrIdx := formatIdx["QR"]; qrIdx >= 0 && qrIdx < len(genoSlice) {
+		genoSlice[qrIdx] = scaleQual(genoSlice[qrIdx], countSlice[0], newCountSlice[0])
+	}
+	if qaIdx := formatIdx["QA"]; qaIdx >= 0 && qaIdx < len(genoSlice) {
+		qaSlice := strings.Split(genoSlice[qaIdx], ",")
+		for idxQual := range qaSlice {
+			if idxQual+1 < len(countSlice) {
+				qaSlice[idxQual] = scaleQual(qaSlice[idxQual], countSlice[idxQual+1], newCountSlice[idxQual+1])
+			}
+		}
+		genoSlice[qaIdx] = strings.Join(qaSlice, ",")
+	}
+
+	newRef := newCountSlice[0]
+	newAlt := 0
+	adStrSlice := []string{}
+	aoStrSlice := []string{}
+	for idxAllele, valCount := range newCountSlice {
+		adStrSlice = append(adStrSlice, strconv.Itoa(valCount))
+		if idxAllele > 0 {
+			aoStrSlice = append(aoStrSlice, strconv.Itoa(valCount))
+			newAlt += valCount
+		}
+	}
+	newGenoMap := map[string]string{
+		"AD": strings.Join(adStrSlice, ","),
+		"DP": strconv.Itoa(newRef + newAlt),
+		"RO": strconv.Itoa(newRef),
+		"AO": strings.Join(aoStrSlice, ","),
+	}
+	switch {
+	case newRef+newAlt == 0:
+		return "./."
+	case newRef == 0:
+		newGenoMap["GT"] = "1/1"
+	case newAlt == 0:
+		newGenoMap["GT"] = "0/0"
+	default:
+		newGenoMap["GT"] = "0/1"
+	}
+	for keyGeno, valGeno := range newGenoMap {
+		if genoIdx := formatIdx[keyGeno]; genoIdx >= 0 && genoIdx < len(genoSlice) {
+			genoSlice[genoIdx] = valGeno
+		}
+	}
+	return strings.Join(genoSlice, ":")
+}
+
+// main function
+func main() {
+	flag.Parse()
+
+	fmt.Println("[", time.Now(), "] ", "Program start ...")
+
+	if *targetDp <= 0 && *targetRat <= 0.0 && !*matchDp {
+		log.Fatalf("one of -dp, -ratio or -match is required")
+	}
+
+	vcfLines, err := getVcfLines(*vcfFile)
+	if err != nil {
+		log.Fatalf("read input vcf file: %s", err)
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	var sampleCols []int
+	outLines := []string{}
+	numSNV := 0
+	numLowDp := 0
+	readSumSlice := []int{}
+	newReadSumSlice := []int{}
+	for _, valLine := range vcfLines {
+		if strings.HasPrefix(valLine, "#CHROM") {
+			sampleCols, err = getSampleCols(valLine, *sampleList)
+			if err != nil {
+				log.Fatalf("read samples from vcf title: %s", err)
+			}
+			readSumSlice = make([]int, len(sampleCols))
+			newReadSumSlice = make([]int, len(sampleCols))
+		}
+		if strings.HasPrefix(valLine, "#") {
+			outLines = append(outLines, valLine)
+			continue
+		}
+		if sampleCols == nil {
+			log.Fatalf("vcf title (#CHROM) is not found before SNVs")
+		}
+
+		lineSlice := strings.Fields(valLine)
+		formatIdx := getFormatIdx(lineSlice[8])
+
+		//target depth of each selected sample at this site, reads of all
+		//alleles (ref and all alt) are downsampled. Ratio and depth cap are
+		//applied first, and -match uses the smallest of these depths
+		countSlices := [][]int{}
+		dpSlice := []int{}
+		newDpSlice := []int{}
+		matchMin := -1
+		for _, valCol := range sampleCols {
+			countSlice := getADcount(lineSlice[valCol], formatIdx)
+			sampleDp := 0
+			for _, valCount := range countSlice {
+				sampleDp += valCount
+			}
+			newDp := sampleDp
+			if *targetRat > 0.0 {
+				newDp = int(math.Round(*targetRat * float64(sampleDp)))
+			}
+			if *targetDp > 0 && newDp > *targetDp {
+				newDp = *targetDp
+			}
+			if newDp > sampleDp {
+				newDp = sampleDp
+			}
+			if sampleDp > 0 && (matchMin < 0 || newDp < matchMin) {
+				matchMin = newDp
+			}
+			countSlices = append(countSlices, countSlice)
+			dpSlice = append(dpSlice, sampleDp)
+			newDpSlice = append(newDpSlice, newDp)
+		}
+		lowDp := false
+		for idxCol := range sampleCols {
+			if *matchDp && matchMin >= 0 && newDpSlice[idxCol] > matchMin {
+				newDpSlice[idxCol] = matchMin
+			}
+			if newDpSlice[idxCol] < *minDp {
+				lowDp = true
+			}
+		}
+		if lowDp {
+			numLowDp++
+			continue
+		}
+		for idxCol, valCol := range sampleCols {
+			readSumSlice[idxCol] += dpSlice[idxCol]
+			newReadSumSlice[idxCol] += newDpSlice[idxCol]
+			if newDpSlice[idxCol] == dpSlice[idxCol] {
+				continue
+			}
+			newCountSlice := sampleHyper(r, countSlices[idxCol], newDpSlice[idxCol])
+			lineSlice[valCol] = downsampleGeno(lineSlice[valCol], formatIdx, countSlices[idxCol], newCountSlice)
+		}
+		outLines = append(outLines, strings.Join(lineSlice, "\t"))
+		numSNV++
+	}
+
+	fmt.Println("Total number of vcf is: ", numSNV)
+	fmt.Println("Total number of vcf removed below minimum depth is: ", numLowDp)
+	for idxSample, valSample := range strings.Split(*sampleList, ",") {
+		if numSNV > 0 {
+			fmt.Println("Average depth of ", valSample, " before and after downsampling is: ",
+				strconv.FormatFloat(float64(readSumSlice[idxSample])/float64(numSNV), 'f', 2, 64),
+				strconv.FormatFloat(float64(newReadSumSlice[idxSample])/float64(numSNV), 'f', 2, 64))
+		}
+	}
+
+	if err := writeLines(outLines, *outFile); err != nil {
+		log.Fatalf("write downsampled vcf file: %s", err)
+	}
+
+	fmt.Println("[", time.Now(), "] ", "Program end ...")
+}
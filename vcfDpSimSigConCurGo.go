@@ -24,9 +24,21 @@ var (
 	outFile   = flag.String("out", "", "Output file with index and sig merged")
 	popStruct = flag.String("p", "", "Population struction: RIL or F2")
 	numIndvl  = flag.Int("n", 0, "Number of individuals in each bulk")
+	numHigh   = flag.Int("nHigh", 0, "Number of individuals in high bulk (WT column), default -n")
+	numLow    = flag.Int("nLow", 0, "Number of individuals in low bulk (MT column), default -n")
 	rep       = flag.Int("r", 0, "Number of replication in simulation")
 	filterVal = flag.Float64("f", 0.3, "Filter value")
 	cpus      = flag.Int("c", 1, "Number of workding CPUs")
+	cacheFile = flag.String("cache", "", "Threshold cache file read before and extended after simulation")
+	gridSize  = flag.Int("grid", 0, "Number of log-spaced depths simulated in each bulk, others are interpolated, default 0 (off)")
+	gridCheck = flag.Int("gridCheck", 10, "Number of depths simulated to report maximum interpolation error")
+	errRate   = flag.Float64("e", 0.0, "Per-base sequencing error rate in simulation, default 0")
+	overDisp  = flag.Float64("od", 0.0, "Beta-binomial overdispersion of read counts in simulation, default 0 (binomial)")
+	refBias   = flag.Float64("bias", 0.5, "Alt read ratio of heterozygous sites in null model (reference bias), default 0.5 (no bias). Keep 0.5 if index was corrected by calAF -bias, otherwise bias is counted twice")
+	ploidy    = flag.Int("ploidy", 2, "Ploidy of the population, e.g. 2, 4 or 6")
+	inherit   = flag.String("inherit", "poly", "Inheritance of polyploids: poly (polysomic, autopolyploid) or di (disomic, allopolyploid)")
+	homeo     = flag.Bool("homeo", false, "Reads of homeologous subgenomes map to SNVs of disomic polyploids (ref allele)")
+	odEst     = flag.Bool("odEst", false, "Estimate overdispersion from genome-wide background, overrides -od")
 )
 
 // getSNVlong function reads lines from vcf file and return a slice type
@@ -49,6 +61,28 @@ func getSNVlong(path string) ([]string, error) {
 	return SNVlong, scanner.Err()
 }
 
+// getSNVtitle function reads title line of pass vcf file and return the
+// column names as a slice type
+func getSNVtitle(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^#ID")
+	titleSlice := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			titleSlice = strings.Fields(line)
+			break
+		}
+	}
+	return titleSlice, scanner.Err()
+}
+
 // writeSNVlong function will write vcf slices in to out file
 func writeSNVlong(SNVlong []string, path string) error {
 	file, err := os.Create(path)
@@ -64,6 +98,48 @@ func writeSNVlong(SNVlong []string, path string) error {
 	return w.Flush()
 }
 
+// getCacheMap function reads simulated thresholds from cache file and return
+// a map type, with simulation parameters and depths as key and depth line as
+// value. A missing cache file is treated as an empty cache
+func getCacheMap(path string) (map[string]string, error) {
+	cacheMap := map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cacheMap, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^[^#]")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			lineSlice := strings.Fields(line)
+			cacheKeySlice := []string{lineSlice[0], lineSlice[1], lineSlice[2]}
+			cacheMap[strings.Join(cacheKeySlice, "_")] = strings.Join(lineSlice[1:], "\t")
+		}
+	}
+	return cacheMap, scanner.Err()
+}
+
+// getCacheKey function will return a key of all simulation parameters which
+// thresholds depend on, depths are appended to this key in threshold cache
+func getCacheKey(popStrut string, numHigh int, numLow int, rep int, filterVal float64, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool) string {
+	cacheKeySlice := []string{
+		popStrut, strconv.Itoa(numHigh), strconv.Itoa(numLow),
+		strconv.Itoa(rep), strconv.FormatFloat(filterVal, 'f', -1, 64),
+		strconv.FormatFloat(errRate, 'f', -1, 64), strconv.FormatFloat(overDisp, 'f', -1, 64),
+		strconv.FormatFloat(refBias, 'f', -1, 64),
+	}
+	if ploidy > 2 {
+		cacheKeySlice = append(cacheKeySlice, strconv.Itoa(ploidy), inherit, strconv.FormatBool(homeo))
+	}
+	return strings.Join(cacheKeySlice, "_")
+}
+
 // getDP function will retrieve depth information from VCF files and store
 // them into a string, this function is used to keep the order of all DPs
 func getDP(vcfLine string) string {
@@ -107,7 +183,8 @@ func getDP(vcfLine string) string {
 }
 
 // getDPmap function will retrieve depth information for two F2s from VCF files and
-// store them in a map
+// store them in a map, the key keeps the order of WT and MT depth as the two
+// bulks may have different number of individuals
 func getDPmap(vcfLines []string) map[string]string {
 	dpMap := map[string]string{}
 	for _, valLine := range vcfLines {
@@ -155,10 +232,100 @@ func getDPmap(vcfLines []string) map[string]string {
 	return dpMap
 }
 
-// genotype function will randomly get genotype given population struction
-func genotype(popStrut string) float64 {
-	count := 0.0
+// getADcount function will return ref and alt read count of a sample
+func getADcount(genoField string) (int, int) {
+	if genoField == "." {
+		return 0, 0
+	}
+	genoSlice := strings.Split(genoField, ":")
+	adSlice := strings.Split(genoSlice[2], ",")
+	refCount, _ := strconv.Atoi(adSlice[0])
+	altCount, _ := strconv.Atoi(adSlice[1])
+	return refCount, altCount
+}
+
+// estimateOverDisp function will estimate beta-binomial overdispersion of read
+// counts by method of moments. Variance of bulk index beyond the variance of
+// bulk genotype and binomial sampling is attributed to overdispersion, and
+// the median over 1 Mb background windows is used to be robust to QTLs
+func estimateOverDisp(vcfLines []string, popStrut string, numHigh int, numLow int, errRate float64) float64 {
+	genoVar := 1.0 / 8.0
 	if popStrut == "RIL" {
+		genoVar = 1.0 / 4.0
+	}
+	bulkVarSlice := []float64{
+		genoVar / float64(numHigh) * (1.0 - 2.0*errRate) * (1.0 - 2.0*errRate),
+		genoVar / float64(numLow) * (1.0 - 2.0*errRate) * (1.0 - 2.0*errRate),
+	}
+
+	windowNumMap := map[string]float64{}
+	windowDenMap := map[string]float64{}
+	for _, valLine := range vcfLines {
+		lineSlice := strings.Fields(valLine)
+		idSlice := strings.Split(lineSlice[0], "_")
+		pos, _ := strconv.Atoi(idSlice[1])
+		windowKey := idSlice[0] + "_" + strconv.Itoa(pos/1000000)
+		for idxBulk, valGeno := range lineSlice[3:5] {
+			refCount, altCount := getADcount(valGeno)
+			dp := float64(refCount + altCount)
+			if dp < 2 {
+				continue
+			}
+			bulkIndex := float64(altCount) / dp
+			readVar := 0.25 - bulkVarSlice[idxBulk]
+			windowNumMap[windowKey] += (bulkIndex-0.5)*(bulkIndex-0.5) - bulkVarSlice[idxBulk] - readVar/dp
+			windowDenMap[windowKey] += readVar * (dp - 1.0) / dp
+		}
+	}
+
+	overDispSlice := []float64{}
+	for keyWindow, valNum := range windowNumMap {
+		if windowDenMap[keyWindow] > 0.0 {
+			overDispSlice = append(overDispSlice, valNum/windowDenMap[keyWindow])
+		}
+	}
+	if len(overDispSlice) == 0 {
+		return 0.0
+	}
+	sort.Float64s(overDispSlice)
+	overDisp := overDispSlice[len(overDispSlice)/2]
+	if overDisp < 0.0 {
+		overDisp = 0.0
+	}
+	if overDisp > 0.99 {
+		overDisp = 0.99
+	}
+	fmt.Println("Overdispersion estimated from ", len(overDispSlice), " background windows is: ", strconv.FormatFloat(overDisp, 'f', 4, 64))
+	return overDisp
+}
+
+// genotype function will randomly get genotype given population struction.
+// For polysomic polyploids, each F2 gamete draws ploidy/2 chromosomes of the
+// F1 without replacement, and RILs are fixed for either allele. For disomic
+// polyploids the SNV segregates as diploid in one subgenome, and its ratio is
+// diluted by 2/ploidy if reads of homeologous subgenomes map to the SNV
+func genotype(popStrut string, ploidy int, inherit string, homeo bool) float64 {
+	count := 0.0
+	if ploidy > 2 && inherit == "di" {
+		count = genotype(popStrut, 2, inherit, homeo)
+		if homeo {
+			count = count * 2.0 / float64(ploidy)
+		}
+	} else if ploidy > 2 && popStrut != "RIL" {
+		for i := 1; i <= 2; i++ {
+			remainAlt := ploidy / 2
+			remainTotal := ploidy
+			for k := 1; k <= ploidy/2; k++ {
+				randge := rng.NewUniformGenerator(time.Now().UnixNano())
+				frq := randge.Float64()
+				if frq < float64(remainAlt)/float64(remainTotal) {
+					count += 1.0 / float64(ploidy)
+					remainAlt--
+				}
+				remainTotal--
+			}
+		}
+	} else if popStrut == "RIL" {
 		randge := rng.NewUniformGenerator(time.Now().UnixNano())
 		frq := randge.Float64()
 
@@ -179,28 +346,47 @@ func genotype(popStrut string) float64 {
 	return count
 }
 
+// getBackgroundIndex function will return expected index of unlinked SNVs
+// given ploidy and inheritance
+func getBackgroundIndex(ploidy int, inherit string, homeo bool) float64 {
+	if ploidy > 2 && inherit == "di" && homeo {
+		return 1.0 / float64(ploidy)
+	}
+	return 0.5
+}
+
 // calIndvlGeno function will calculate individual genotype using randomly
 // generated genotype
-func calIndvlGeno(numIndvl int, popStrut string) float64 {
+func calIndvlGeno(numIndvl int, popStrut string, ploidy int, inherit string, homeo bool) float64 {
 	genoTotal := 0.0
 	for j := 1; j <= numIndvl; j++ {
-		genoTotal += genotype(popStrut)
+		genoTotal += genotype(popStrut, ploidy, inherit, homeo)
 	}
 	indvlGeno := genoTotal / float64(int64(numIndvl))
 	return indvlGeno
 }
 
 // calIndvlIndex function will calculate indeividual index using binomial
-// distribution with given depth and expected genotype ratio (default 0.3)
-func calIndvlIndex(dp int, ratioGeno float64) float64 {
+// distribution with given depth and expected genotype ratio (default 0.3).
+// The ratio is shifted by sequencing error, and drawn from a beta distribution
+// if read counts are overdispersed (beta-binomial). Reference bias shifts the
+// ratio by relative mapping efficiency of alt reads w = refBias/(1-refBias)
+func calIndvlIndex(dp int, ratioGeno float64, errRate float64, overDisp float64, refBias float64) float64 {
+	biasWeight := refBias / (1.0 - refBias)
+	ratioGeno = biasWeight * ratioGeno / (biasWeight*ratioGeno + 1.0 - ratioGeno)
+	ratioRead := ratioGeno*(1.0-errRate) + (1.0-ratioGeno)*errRate
+	if overDisp > 0.0 && ratioRead > 0.0 && ratioRead < 1.0 {
+		betage := rng.NewBetaGenerator(time.Now().UnixNano())
+		ratioRead = betage.Beta(ratioRead*(1.0-overDisp)/overDisp, (1.0-ratioRead)*(1.0-overDisp)/overDisp)
+	}
 	binomalge := rng.NewBinomialGenerator(time.Now().UnixNano())
-	indvlIndex := binomalge.Binomial(int64(dp), ratioGeno)
+	indvlIndex := binomalge.Binomial(int64(dp), ratioRead)
 	indvlIndexAve := float64(indvlIndex) / float64(dp)
 	return indvlIndexAve
 }
 
 // simIndex function will do QTL simulation with given times of replication
-func simIndex(numIndvl int, dpSlice []int, rep int, filterVal float64, popStrut string) []float64 {
+func simIndex(numHigh int, numLow int, dpSlice []int, rep int, filterVal float64, popStrut string, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool) []float64 {
 	p90L := 0.0
 	p90H := 0.0
 	p95L := 0.0
@@ -209,10 +395,10 @@ func simIndex(numIndvl int, dpSlice []int, rep int, filterVal float64, popStrut
 	p99H := 0.0
 	delIndvlIndexSlice := []float64{}
 	for k := 1; k <= rep; k++ {
-		wtRatioGeno := calIndvlGeno(numIndvl, popStrut)
-		wtIndvlIndex := calIndvlIndex(dpSlice[0], wtRatioGeno)
-		mtRatioGeno := calIndvlGeno(numIndvl, popStrut)
-		mtIndvlIndex := calIndvlIndex(dpSlice[1], mtRatioGeno)
+		wtRatioGeno := calIndvlGeno(numHigh, popStrut, ploidy, inherit, homeo)
+		wtIndvlIndex := calIndvlIndex(dpSlice[0], wtRatioGeno, errRate, overDisp, refBias)
+		mtRatioGeno := calIndvlGeno(numLow, popStrut, ploidy, inherit, homeo)
+		mtIndvlIndex := calIndvlIndex(dpSlice[1], mtRatioGeno, errRate, overDisp, refBias)
 
 		if wtIndvlIndex >= filterVal || mtIndvlIndex >= filterVal {
 			delIndvlIndex := wtIndvlIndex - mtIndvlIndex
@@ -264,16 +450,125 @@ func simIndex(numIndvl int, dpSlice []int, rep int, filterVal float64, popStrut
 	return sigSlice
 }
 
+// makeDepthGrid function will make log-spaced depths covering all depths of
+// both bulks, with gridSize depths at most
+func makeDepthGrid(dpKeySlice []string, gridSize int) []int {
+	minDp := math.MaxInt32
+	maxDp := 1
+	for _, keyDp := range dpKeySlice {
+		for _, valDp := range strings.Split(keyDp, "_") {
+			dp, _ := strconv.Atoi(valDp)
+			if dp < minDp {
+				minDp = dp
+			}
+			if dp > maxDp {
+				maxDp = dp
+			}
+		}
+	}
+
+	dpGridSlice := []int{minDp}
+	if gridSize > 1 {
+		logStep := (math.Log(float64(maxDp)) - math.Log(float64(minDp))) / float64(gridSize-1)
+		for i := 1; i < gridSize; i++ {
+			dp := int(math.Round(math.Exp(math.Log(float64(minDp)) + logStep*float64(i))))
+			if dp > dpGridSlice[len(dpGridSlice)-1] {
+				dpGridSlice = append(dpGridSlice, dp)
+			}
+		}
+	}
+	if dpGridSlice[len(dpGridSlice)-1] < maxDp {
+		dpGridSlice = append(dpGridSlice, maxDp)
+	}
+	return dpGridSlice
+}
+
+// getCheckKeys function will select evenly spaced depths which are not on the
+// depth grid, these depths are simulated to check interpolation error
+func getCheckKeys(dpKeySlice []string, dpGridSlice []int, numCheck int) []string {
+	gridMap := map[int]bool{}
+	for _, valGrid := range dpGridSlice {
+		gridMap[valGrid] = true
+	}
+	offGridSlice := []string{}
+	for _, keyDp := range dpKeySlice {
+		keyDpSlice := strings.Split(keyDp, "_")
+		wtDp, _ := strconv.Atoi(keyDpSlice[0])
+		mtDp, _ := strconv.Atoi(keyDpSlice[1])
+		if !gridMap[wtDp] || !gridMap[mtDp] {
+			offGridSlice = append(offGridSlice, keyDp)
+		}
+	}
+
+	checkKeySlice := []string{}
+	if numCheck <= 0 || len(offGridSlice) == 0 {
+		return checkKeySlice
+	}
+	if numCheck > len(offGridSlice) {
+		numCheck = len(offGridSlice)
+	}
+	for i := 0; i < numCheck; i++ {
+		checkKeySlice = append(checkKeySlice, offGridSlice[i*len(offGridSlice)/numCheck])
+	}
+	return checkKeySlice
+}
+
+// getGridBracket function will return indexes of the two grid depths around
+// a depth and the weight of the upper one in log scale
+func getGridBracket(dp int, dpGridSlice []int) (int, int, float64) {
+	upIdx := sort.SearchInts(dpGridSlice, dp)
+	if upIdx >= len(dpGridSlice) {
+		upIdx = len(dpGridSlice) - 1
+	}
+	if dpGridSlice[upIdx] == dp || upIdx == 0 {
+		return upIdx, upIdx, 0.0
+	}
+	lowIdx := upIdx - 1
+	logLow := math.Log(float64(dpGridSlice[lowIdx]))
+	logUp := math.Log(float64(dpGridSlice[upIdx]))
+	return lowIdx, upIdx, (math.Log(float64(dp)) - logLow) / (logUp - logLow)
+}
+
+// interpolateIndex function will bilinearly interpolate thresholds of a depth
+// pair from simulated thresholds of the grid depths around it
+func interpolateIndex(keyDp string, dpGridSlice []int, simLineMap map[string]string) string {
+	keyDpSlice := strings.Split(keyDp, "_")
+	wtDp, _ := strconv.Atoi(keyDpSlice[0])
+	mtDp, _ := strconv.Atoi(keyDpSlice[1])
+	wtLow, wtUp, wtWeight := getGridBracket(wtDp, dpGridSlice)
+	mtLow, mtUp, mtWeight := getGridBracket(mtDp, dpGridSlice)
+
+	cornerSlice := [][]float64{}
+	for _, valCorner := range [][]int{{wtLow, mtLow}, {wtLow, mtUp}, {wtUp, mtLow}, {wtUp, mtUp}} {
+		cornerKey := strconv.Itoa(dpGridSlice[valCorner[0]]) + "_" + strconv.Itoa(dpGridSlice[valCorner[1]])
+		cornerFloat := []float64{}
+		for _, valSim := range strings.Fields(simLineMap[cornerKey])[2:] {
+			simFloat, _ := strconv.ParseFloat(valSim, 64)
+			cornerFloat = append(cornerFloat, simFloat)
+		}
+		cornerSlice = append(cornerSlice, cornerFloat)
+	}
+
+	vcfDpIndexSlice := []string{keyDpSlice[0], keyDpSlice[1]}
+	for i := range cornerSlice[0] {
+		lowVal := cornerSlice[0][i]*(1-mtWeight) + cornerSlice[1][i]*mtWeight
+		upVal := cornerSlice[2][i]*(1-mtWeight) + cornerSlice[3][i]*mtWeight
+		interVal := lowVal*(1-wtWeight) + upVal*wtWeight
+		vcfDpIndexSlice = append(vcfDpIndexSlice, strconv.FormatFloat(interVal, 'f', 2, 64))
+	}
+	return strings.Join(vcfDpIndexSlice, "\t")
+}
+
 // worker function makes working pools to do QTL simulation and return 4 confidence
 // intervals: 95% low, 95% high, 99% low, 99% high
-func worker(numIndvl int, rep int, filterVal float64, popStrut string, jobs <-chan string, results chan<- string) {
+func worker(numHigh int, numLow int, rep int, filterVal float64, popStrut string, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool, jobs <-chan string, results chan<- string) {
 	for j := range jobs {
 		keyDpSlice := strings.Split(j, "_")
 		wtDp, _ := strconv.Atoi(keyDpSlice[0])
 		mtDp, _ := strconv.Atoi(keyDpSlice[1])
 
 		dpIntSlice := []int{wtDp, mtDp}
-		dpSimIndex := simIndex(numIndvl, dpIntSlice, rep, filterVal, popStrut)
+		dpSimIndex := simIndex(numHigh, numLow, dpIntSlice, rep, filterVal, popStrut, errRate, overDisp, refBias, ploidy, inherit, homeo)
 		vcfDpIndexSlice := []string{keyDpSlice[0], keyDpSlice[1]}
 		for _, valDpSim := range dpSimIndex {
 			vcfDpIndexSlice = append(vcfDpIndexSlice, strconv.FormatFloat(valDpSim, 'f', 2, 64))
@@ -304,6 +599,11 @@ func main() {
 		log.Fatalf("read input pass vcf file: %s", err)
 	}
 
+	vcfTitle, err := getSNVtitle(*passFile)
+	if err != nil {
+		log.Fatalf("read title of input pass vcf file: %s", err)
+	}
+
 	vcfDpMap := getDPmap(vcfLines)
 
 	indexHeader := []string{
@@ -311,25 +611,144 @@ func main() {
 		"p95L", "p95H", "p99L", "p99H",
 	}
 
+	if *numHigh == 0 {
+		*numHigh = *numIndvl
+	}
+	if *numLow == 0 {
+		*numLow = *numIndvl
+	}
+	fmt.Println("Number of individuals in high and low bulk is: ", *numHigh, *numLow)
+
 	numThreads := maxParallelism(*cpus)
 	fmt.Println("Total available CPU number is: ", runtime.NumCPU())
 	fmt.Println("Working CPU number is: ", numThreads)
-	jobs := make(chan string, len(vcfDpMap))
-	results := make(chan string, len(vcfDpMap))
+
+	//read thresholds simulated in previous runs
+	cacheMap := map[string]string{}
+	if *cacheFile != "" {
+		cacheMap, err = getCacheMap(*cacheFile)
+		if err != nil {
+			log.Fatalf("read threshold cache file: %s", err)
+		}
+	}
+	if *odEst {
+		//estimate is rounded, so reruns on the same data share cached thresholds
+		*overDisp = math.Round(estimateOverDisp(vcfLines, *popStruct, *numHigh, *numLow, *errRate)*10000.0) / 10000.0
+	}
+	fmt.Println("Sequencing error rate and overdispersion is: ", *errRate, strconv.FormatFloat(*overDisp, 'f', 4, 64))
+	if *refBias <= 0.0 || *refBias >= 1.0 {
+		log.Fatalf("reference bias %f is not between 0 and 1", *refBias)
+	}
+	fmt.Println("Alt read ratio of heterozygous sites in null model is: ", *refBias)
+
+	//filter value is scaled with expected index of unlinked SNVs
+	if *ploidy < 2 || *ploidy%2 != 0 {
+		log.Fatalf("ploidy %d is not an even number of at least 2", *ploidy)
+	}
+	if *inherit != "poly" && *inherit != "di" {
+		log.Fatalf("unknown -inherit %s: poly or di", *inherit)
+	}
+	bgIndex := getBackgroundIndex(*ploidy, *inherit, *homeo)
+	fmt.Println("Ploidy and inheritance is: ", *ploidy, *inherit)
+	fmt.Println("Expected background index is: ", strconv.FormatFloat(bgIndex, 'f', 4, 64))
+	if bgIndex != 0.5 {
+		*filterVal = *filterVal * bgIndex / 0.5
+		fmt.Println("Filter value scaled to expected background index is: ", strconv.FormatFloat(*filterVal, 'f', 4, 64))
+	}
+	cacheKey := getCacheKey(*popStruct, *numHigh, *numLow, *rep, *filterVal, *errRate, *overDisp, *refBias, *ploidy, *inherit, *homeo)
+
+	//depths to simulate, either all depths or a grid of depths
+	dpKeySlice := []string{}
+	for keyDpMap := range vcfDpMap {
+		dpKeySlice = append(dpKeySlice, keyDpMap)
+	}
+	sort.Strings(dpKeySlice)
+	dpGridSlice := []int{}
+	checkKeySlice := []string{}
+	if *gridSize > 0 {
+		dpGridSlice = makeDepthGrid(dpKeySlice, *gridSize)
+		fmt.Println("Depth grid is: ", dpGridSlice)
+		checkKeySlice = getCheckKeys(dpKeySlice, dpGridSlice, *gridCheck)
+		dpKeySlice = []string{}
+		for _, valWtGrid := range dpGridSlice {
+			for _, valMtGrid := range dpGridSlice {
+				dpKeySlice = append(dpKeySlice, strconv.Itoa(valWtGrid)+"_"+strconv.Itoa(valMtGrid))
+			}
+		}
+		dpKeySlice = append(dpKeySlice, checkKeySlice...)
+	}
+
+	simLineMap := map[string]string{}
+	simDpSlice := []string{}
+	for _, keyDp := range dpKeySlice {
+		if valCache, ok := cacheMap[cacheKey+"_"+keyDp]; ok {
+			simLineMap[keyDp] = valCache
+		} else {
+			simDpSlice = append(simDpSlice, keyDp)
+		}
+	}
+	fmt.Println("Total number of depth found in cache is: ", len(dpKeySlice)-len(simDpSlice))
+
+	jobs := make(chan string, len(simDpSlice))
+	results := make(chan string, len(simDpSlice))
 
 	for w := 1; w <= numThreads; w++ {
-		go worker(*numIndvl, *rep, *filterVal, *popStruct, jobs, results)
+		go worker(*numHigh, *numLow, *rep, *filterVal, *popStruct, *errRate, *overDisp, *refBias, *ploidy, *inherit, *homeo, jobs, results)
 	}
 
-	for keyDpMap, _ := range vcfDpMap {
-		jobs <- keyDpMap
+	for _, keyDp := range simDpSlice {
+		jobs <- keyDp
 	}
 	close(jobs)
 
+	simDpLines := []string{}
+	for a := 1; a <= len(simDpSlice); a++ {
+		simDpLine := <-results
+		simDpLineSlice := strings.Fields(simDpLine)
+		simLineMap[strings.Join(simDpLineSlice[:2], "_")] = simDpLine
+		simDpLines = append(simDpLines, simDpLine)
+	}
+
+	//extend threshold cache with new simulated depths
+	if *cacheFile != "" && len(simDpLines) > 0 {
+		cacheLines := []string{"#KEY\tDP_wt\tDP_mt\tp90L\tp90H\tp95L\tp95H\tp99L\tp99H"}
+		for keyCache, valCache := range cacheMap {
+			keyCacheSlice := strings.Split(keyCache, "_")
+			keyParam := strings.Join(keyCacheSlice[:len(keyCacheSlice)-2], "_")
+			cacheLines = append(cacheLines, keyParam+"\t"+valCache)
+		}
+		for _, valDpLine := range simDpLines {
+			cacheLines = append(cacheLines, cacheKey+"\t"+valDpLine)
+		}
+		if err := writeSNVlong(cacheLines, *cacheFile); err != nil {
+			log.Fatalf("write threshold cache file: %s", err)
+		}
+		fmt.Println("Total number of depth added to cache is: ", len(simDpLines))
+	}
+
 	dpLines := []string{}
 	dpLines = append(dpLines, strings.Join(indexHeader, "\t"))
-	for a := 1; a <= len(vcfDpMap); a++ {
-		dpLines = append(dpLines, <-results)
+	if *gridSize > 0 {
+		for keyDpMap := range vcfDpMap {
+			dpLines = append(dpLines, interpolateIndex(keyDpMap, dpGridSlice, simLineMap))
+		}
+		maxErr := 0.0
+		for _, keyCheck := range checkKeySlice {
+			simSlice := strings.Fields(simLineMap[keyCheck])
+			interSlice := strings.Fields(interpolateIndex(keyCheck, dpGridSlice, simLineMap))
+			for i := 2; i < len(simSlice); i++ {
+				simVal, _ := strconv.ParseFloat(simSlice[i], 64)
+				interVal, _ := strconv.ParseFloat(interSlice[i], 64)
+				if math.Abs(simVal-interVal) > maxErr {
+					maxErr = math.Abs(simVal - interVal)
+				}
+			}
+		}
+		fmt.Println("Maximum interpolation error in ", len(checkKeySlice), " checked depths is: ", strconv.FormatFloat(maxErr, 'f', 2, 64))
+	} else {
+		for keyDpMap := range vcfDpMap {
+			dpLines = append(dpLines, simLineMap[keyDpMap])
+		}
 	}
 
 	//write skip snv file
@@ -351,13 +770,19 @@ func main() {
 		"deltaIdx_F2", "dp_WT", "dp_MT", "p90L", "p90H",
 		"p95L", "p95H", "p99L", "p99H",
 	}
+	if len(vcfTitle) > 11 {
+		outHeader = append(outHeader, vcfTitle[11:]...)
+	}
 
 	mergedVcfLines := []string{}
 	mergedVcfLines = append(mergedVcfLines, strings.Join(outHeader, "\t"))
 	for _, valVcfLine := range vcfLines {
 		vcfDpKey := getDP(valVcfLine)
 		if valDp, ok := dpLineMap[vcfDpKey]; ok {
-			newVcfSlice := []string{valVcfLine, valDp}
+			//extra columns of pass vcf are kept after depth and significance
+			vcfLineSlice := strings.Fields(valVcfLine)
+			newVcfSlice := []string{strings.Join(vcfLineSlice[:11], "\t"), valDp}
+			newVcfSlice = append(newVcfSlice, vcfLineSlice[11:]...)
 			mergedVcfLines = append(mergedVcfLines, strings.Join(newVcfSlice, "\t"))
 		} else {
 			fmt.Println("Skiped vcf: ", valVcfLine)
@@ -16,12 +16,13 @@ import (
 
 // declare command arguments
 var (
-	bedFile  = flag.String("bed", "", "Input chromosome file")
+	bedFile  = flag.String("bed", "", "Input gene file in BED4, BED6 or BED12 format (0-based start), output keeps CHR START END NAME STRAND (+ if absent) before the averaged indices")
 	vcfFile  = flag.String("vcf", "", "Input vcf file")
 	outFile  = flag.String("out", "", "Output file with index in sliding windows")
 	cpus     = flag.Int("c", 1, "Number of working CPUs")
-	upSize   = flag.Int("up", 0, "Up stream flanking region, default 0")
-	downSize = flag.Int("down", 0, "Down stream flanking region, default 0")
+	upSize   = flag.Int("up", 0, "Up stream flanking region (strand-aware), default 0")
+	downSize = flag.Int("down", 0, "Down stream flanking region (strand-aware), default 0")
+	exonOnly = flag.Bool("exon", true, "Only use SNVs in exons (and flanking regions) of BED12 genes")
 )
 
 // getLines function reads lines from vcf file and return a slice,
@@ -33,12 +34,12 @@ func getLines(path string) ([]string, error) {
 	}
 	defer file.Close()
 
-	header, _ := regexp.Compile("^[^#]")
+	header, _ := regexp.Compile("^(#|track|browser)")
 	lines := []string{}
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if header.MatchString(line) {
+		if !header.MatchString(line) && len(strings.TrimSpace(line)) > 0 {
 			lines = append(lines, line)
 		}
 	}
@@ -62,7 +63,7 @@ func getSNVlong(path string) (map[string]string, error) {
 		if header.MatchString(line) {
 			lineSlice := strings.Fields(line)
 			idSlice := strings.Split(lineSlice[0], "_")
-			idKey := strings.Join(idSlice[:2], "_")
+			idKey := strings.Join(idSlice[:len(idSlice)-2], "_")
 			idVal := strings.Join(lineSlice[5:], "\t")
 			SNVlong[idKey] = idVal
 		}
@@ -85,8 +86,9 @@ func writeLines(SNVlong []string, path string) error {
 	return w.Flush()
 }
 
-// calculateAveIndex function will calculate avergege index
-func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
+// calculateAveIndex function will calculate avergege index of SNVs in regions
+// (1-based closed intervals) of a gene
+func calculateAveIndex(chr string, binRegions [][]int, vcfLine map[string]string) string {
 	indexSlice := []string{}
 	v3IndexSum := 0.0
 	ceIndexSum := 0.0
@@ -104,45 +106,47 @@ func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
 	p99HSum := 0.0
 
 	numVcf := 0
-	for j := binLine[1]; j <= binLine[2]; j++ {
-		var posBuffer bytes.Buffer
-		posBuffer.WriteString(strconv.Itoa(binLine[0]))
-		posBuffer.WriteString("_")
-		posBuffer.WriteString(strconv.Itoa(j))
-		posKey := posBuffer.String()
-		if posVal, ok := vcfLine[posKey]; ok {
-			posValSlice := strings.Fields(posVal)
-			v3VcfIndex, _ := strconv.ParseFloat(posValSlice[0], 64)
-			ceVcfIndex, _ := strconv.ParseFloat(posValSlice[1], 64)
-			wtVcfIndex, _ := strconv.ParseFloat(posValSlice[2], 64)
-			mtVcfIndex, _ := strconv.ParseFloat(posValSlice[3], 64)
-			paVcfIndex, _ := strconv.ParseFloat(posValSlice[4], 64)
-			f2VcfIndex, _ := strconv.ParseFloat(posValSlice[5], 64)
-			wtVcfDp, _ := strconv.ParseFloat(posValSlice[6], 64)
-			mtVcfDp, _ := strconv.ParseFloat(posValSlice[7], 64)
-			p90LVcfDp, _ := strconv.ParseFloat(posValSlice[8], 64)
-			p90HVcfDp, _ := strconv.ParseFloat(posValSlice[9], 64)
-			p95LVcfDp, _ := strconv.ParseFloat(posValSlice[10], 64)
-			p95HVcfDp, _ := strconv.ParseFloat(posValSlice[11], 64)
-			p99LVcfDp, _ := strconv.ParseFloat(posValSlice[12], 64)
-			p99HVcfDp, _ := strconv.ParseFloat(posValSlice[13], 64)
-
-			v3IndexSum += v3VcfIndex
-			ceIndexSum += ceVcfIndex
-			wtIndexSum += wtVcfIndex
-			mtIndexSum += mtVcfIndex
-			paIndexSum += paVcfIndex
-			f2IndexSum += f2VcfIndex
-			wtDpSum += wtVcfDp
-			mtDpSum += mtVcfDp
-			p90LSum += p90LVcfDp
-			p90HSum += p90HVcfDp
-			p95LSum += p95LVcfDp
-			p95HSum += p95HVcfDp
-			p99LSum += p99LVcfDp
-			p99HSum += p99HVcfDp
-
-			numVcf++
+	for _, binLine := range binRegions {
+		for j := binLine[0]; j <= binLine[1]; j++ {
+			var posBuffer bytes.Buffer
+			posBuffer.WriteString(chr)
+			posBuffer.WriteString("_")
+			posBuffer.WriteString(strconv.Itoa(j))
+			posKey := posBuffer.String()
+			if posVal, ok := vcfLine[posKey]; ok {
+				posValSlice := strings.Fields(posVal)
+				v3VcfIndex, _ := strconv.ParseFloat(posValSlice[0], 64)
+				ceVcfIndex, _ := strconv.ParseFloat(posValSlice[1], 64)
+				wtVcfIndex, _ := strconv.ParseFloat(posValSlice[2], 64)
+				mtVcfIndex, _ := strconv.ParseFloat(posValSlice[3], 64)
+				paVcfIndex, _ := strconv.ParseFloat(posValSlice[4], 64)
+				f2VcfIndex, _ := strconv.ParseFloat(posValSlice[5], 64)
+				wtVcfDp, _ := strconv.ParseFloat(posValSlice[6], 64)
+				mtVcfDp, _ := strconv.ParseFloat(posValSlice[7], 64)
+				p90LVcfDp, _ := strconv.ParseFloat(posValSlice[8], 64)
+				p90HVcfDp, _ := strconv.ParseFloat(posValSlice[9], 64)
+				p95LVcfDp, _ := strconv.ParseFloat(posValSlice[10], 64)
+				p95HVcfDp, _ := strconv.ParseFloat(posValSlice[11], 64)
+				p99LVcfDp, _ := strconv.ParseFloat(posValSlice[12], 64)
+				p99HVcfDp, _ := strconv.ParseFloat(posValSlice[13], 64)
+
+				v3IndexSum += v3VcfIndex
+				ceIndexSum += ceVcfIndex
+				wtIndexSum += wtVcfIndex
+				mtIndexSum += mtVcfIndex
+				paIndexSum += paVcfIndex
+				f2IndexSum += f2VcfIndex
+				wtDpSum += wtVcfDp
+				mtDpSum += mtVcfDp
+				p90LSum += p90LVcfDp
+				p90HSum += p90HVcfDp
+				p95LSum += p95LVcfDp
+				p95HSum += p95HVcfDp
+				p99LSum += p99LVcfDp
+				p99HSum += p99HVcfDp
+
+				numVcf++
+			}
 		}
 	}
 	v3IndexAve := 0.0
@@ -178,9 +182,6 @@ func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
 		p99HAve = p99HSum / float64(numVcf)
 	}
 
-	indexSlice = append(indexSlice, strconv.Itoa(binLine[0]))
-	indexSlice = append(indexSlice, strconv.Itoa(binLine[1]))
-	indexSlice = append(indexSlice, strconv.Itoa(binLine[2]))
 	indexSlice = append(indexSlice, strconv.Itoa(numVcf))
 	indexSlice = append(indexSlice, strconv.FormatFloat(v3IndexAve, 'f', 2, 64))
 	indexSlice = append(indexSlice, strconv.FormatFloat(ceIndexAve, 'f', 2, 64))
@@ -200,41 +201,117 @@ func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
 	return strings.Join(indexSlice, "\t")
 }
 
-//get flanking regions
-func getGeneBin(binLine []string, upSize int, downSize int) []string {
+// getExonRegions function will get exon regions (1-based closed intervals)
+// of a BED12 gene from blockSizes and blockStarts
+func getExonRegions(binSlice []string, chromStart int) ([][]int, error) {
+	sizeSlice := strings.Split(strings.TrimSuffix(binSlice[10], ","), ",")
+	startSlice := strings.Split(strings.TrimSuffix(binSlice[11], ","), ",")
+	if len(sizeSlice) != len(startSlice) {
+		return nil, fmt.Errorf("gene %s has %d blockSizes but %d blockStarts", binSlice[3], len(sizeSlice), len(startSlice))
+	}
+	exonRegions := [][]int{}
+	for idxBlock := range sizeSlice {
+		blockSize, err := strconv.Atoi(sizeSlice[idxBlock])
+		if err != nil {
+			return nil, err
+		}
+		blockStart, err := strconv.Atoi(startSlice[idxBlock])
+		if err != nil {
+			return nil, err
+		}
+		exonRegions = append(exonRegions, []int{chromStart + blockStart + 1, chromStart + blockStart + blockSize})
+	}
+	return exonRegions, nil
+}
+
+// getGeneBin function will get flanking regions of genes. BED start is
+// 0-based and converted to 1-based, upstream and downstream flanks follow the
+// strand (column 6, "+" if missing), and for BED12 genes only exons and flanks
+// are used with exonOnly. Each gene is returned as chromosome, flanked start,
+// flanked end, name, strand and regions (start-end, comma separated)
+func getGeneBin(binLine []string, upSize int, downSize int, exonOnly bool) ([]string, error) {
 	newGeneFlank := []string{}
 	for _, valGeneBin := range binLine {
 		binSlice := strings.Fields(valGeneBin)
-		chrPos, _ := strconv.Atoi(binSlice[0])
-		startPos, _ := strconv.Atoi(binSlice[1])
+		if len(binSlice) < 3 {
+			return nil, fmt.Errorf("gene line has less than 3 columns: %s", valGeneBin)
+		}
+		chromStart, err := strconv.Atoi(binSlice[1])
+		if err != nil {
+			return nil, err
+		}
+		endPos, err := strconv.Atoi(binSlice[2])
+		if err != nil {
+			return nil, err
+		}
+		startPos := chromStart + 1
+		geneName := binSlice[0] + ":" + strconv.Itoa(startPos) + "-" + binSlice[2]
+		if len(binSlice) >= 4 {
+			geneName = binSlice[3]
+		}
+		geneStrand := "+"
+		if len(binSlice) >= 6 && binSlice[5] == "-" {
+			geneStrand = "-"
+		}
+
 		newStartPos := startPos - upSize
+		newEndPos := endPos + downSize
+		if geneStrand == "-" {
+			newStartPos = startPos - downSize
+			newEndPos = endPos + upSize
+		}
 		if newStartPos < 1 {
 			newStartPos = 1
 		}
-		endPos, _ := strconv.Atoi(binSlice[2])
-		newEndPos := endPos + downSize
-		binPosSlice := []int{chrPos, newStartPos, newEndPos}
-		binFlankSlice := []string{}
-		for _, valBinPosSlice := range binPosSlice {
-			binFlankSlice = append(binFlankSlice, strconv.Itoa(valBinPosSlice))
+
+		binRegions := [][]int{{newStartPos, newEndPos}}
+		if exonOnly && len(binSlice) >= 12 {
+			exonRegions, err := getExonRegions(binSlice, chromStart)
+			if err != nil {
+				return nil, err
+			}
+			binRegions = [][]int{}
+			if newStartPos < startPos {
+				binRegions = append(binRegions, []int{newStartPos, startPos - 1})
+			}
+			binRegions = append(binRegions, exonRegions...)
+			if newEndPos > endPos {
+				binRegions = append(binRegions, []int{endPos + 1, newEndPos})
+			}
+		}
+		regionSlice := []string{}
+		for _, valRegion := range binRegions {
+			regionSlice = append(regionSlice, strconv.Itoa(valRegion[0])+"-"+strconv.Itoa(valRegion[1]))
 		}
-		binFlankSlice = append(binFlankSlice, binSlice[3])
-		binFlankStr := strings.Join(binFlankSlice, "\t")
-		newGeneFlank = append(newGeneFlank, binFlankStr)
+
+		binFlankSlice := []string{
+			binSlice[0], strconv.Itoa(newStartPos), strconv.Itoa(newEndPos),
+			geneName, geneStrand, strings.Join(regionSlice, ","),
+		}
+		newGeneFlank = append(newGeneFlank, strings.Join(binFlankSlice, "\t"))
 	}
-	return newGeneFlank
+	return newGeneFlank, nil
+}
+
+// getGeneKey function will get chromosome, start, end and name of a gene line
+// as key of the gene
+func getGeneKey(geneLine string) string {
+	return strings.Join(strings.Split(geneLine, "\t")[:4], "\t")
 }
 
 //worker function for making worker pools
 func worker(vcfLine map[string]string, jobs <-chan string, results chan<- string) {
 	for j := range jobs {
-		binSlice := strings.Fields(j)
-		chrPos, _ := strconv.Atoi(binSlice[0])
-		startPos, _ := strconv.Atoi(binSlice[1])
-		endPos, _ := strconv.Atoi(binSlice[2])
-		binPosSlice := []int{chrPos, startPos, endPos}
-		geneAveIndex := []string{binSlice[3]}
-		geneAveIndex = append(geneAveIndex, calculateAveIndex(binPosSlice, vcfLine))
+		binSlice := strings.Split(j, "\t")
+		binRegions := [][]int{}
+		for _, valRegion := range strings.Split(binSlice[5], ",") {
+			regionSlice := strings.Split(valRegion, "-")
+			startPos, _ := strconv.Atoi(regionSlice[0])
+			endPos, _ := strconv.Atoi(regionSlice[1])
+			binRegions = append(binRegions, []int{startPos, endPos})
+		}
+		geneAveIndex := append([]string{}, binSlice[:5]...)
+		geneAveIndex = append(geneAveIndex, calculateAveIndex(binSlice[0], binRegions, vcfLine))
 		geneAveIndexStr := strings.Join(geneAveIndex, "\t")
 
 		results <- geneAveIndexStr
@@ -270,7 +347,10 @@ func main() {
 		log.Fatalf("read input bed file: %s", err)
 	}
 
-	binSlice := getGeneBin(bedLines, *upSize, *downSize)
+	binSlice, err := getGeneBin(bedLines, *upSize, *downSize, *exonOnly)
+	if err != nil {
+		log.Fatalf("read gene regions: %s", err)
+	}
 	fmt.Println("The total number of genes is: ", len(binSlice))
 
 	//start worker
@@ -293,14 +373,11 @@ func main() {
 
 	mapBinLines := map[string]string{}
 	for _, valBinLine := range binLines {
-		valBinLineSlice := strings.Fields(valBinLine)
-		keyMapBinLines := []string{valBinLineSlice[1], valBinLineSlice[2], valBinLineSlice[3], valBinLineSlice[0]}
-		valMapBinlines := append(keyMapBinLines, valBinLineSlice[4:]...)
-		mapBinLines[strings.Join(keyMapBinLines, "\t")] = strings.Join(valMapBinlines, "\t")
+		mapBinLines[getGeneKey(valBinLine)] = valBinLine
 	}
 
 	binHeader := []string{
-		"#CHR", "START", "END", "mid_pos", "num_SNVs",
+		"#CHR", "START", "END", "NAME", "STRAND", "num_SNVs",
 		"AveIdx_v3", "AveIdx_ce", "AveIdx_wt", "AveIdx_mt",
 		"AveIdx_parent", "AveIdx_F2", "AveDp_wt", "AveDp_mt",
 		"Ave_p90L", "Ave_p90H", "Ave_p95L", "Ave_p95H", "Ave_p99L", "Ave_p99H",
@@ -309,7 +386,7 @@ func main() {
 	newBinLines := []string{}
 	newBinLines = append(newBinLines, strings.Join(binHeader, "\t"))
 	for _, valBinSlice := range binSlice {
-		newBinLines = append(newBinLines, mapBinLines[valBinSlice])
+		newBinLines = append(newBinLines, mapBinLines[getGeneKey(valBinSlice)])
 	}
 
 	//write skip snv file
@@ -0,0 +1,339 @@
+// This tool is used to segment delta SNP index along chromosomes with a
+// hidden Markov model (MULTIPOOL-style). Bulk allele counts of each SNV are
+// modelled by three hidden states: unlinked, linked with higher or lower
+// frequency of parent v3 allele in WT bulk, and transitions depend on
+// recombination between neighbouring SNVs. Posterior probability of linkage
+// is reported for each SNV, and the most likely causal location with credible
+// interval is reported for each linked region.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// declare command arguments
+var (
+	passFile  = flag.String("in", "", "Input pass index file")
+	outFile   = flag.String("out", "", "Output file with posterior probability of linkage of each SNV")
+	qtlFile   = flag.String("qtl", "", "Output file with causal location and credible interval of linked regions")
+	cmPerMb   = flag.Float64("cmPerMb", 4.0, "Recombination rate in cM per Mb")
+	freqShift = flag.Float64("shift", 0.3, "Delta SNP index (WT - MT) at causal location")
+	linkProb  = flag.Float64("pLink", 0.01, "Prior probability of linked states")
+	credProb  = flag.Float64("ci", 0.95, "Probability of credible interval of causal location")
+)
+
+// snvCount stores position and polarized allele counts of both bulks
+type snvCount struct {
+	id        string
+	pos       int
+	wtAlt     float64
+	wtRef     float64
+	mtAlt     float64
+	mtRef     float64
+	postSlice []float64
+}
+
+// getSNVlong function reads lines from index file and return a slice type
+func getSNVlong(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^[^#]")
+	SNVlong := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			SNVlong = append(SNVlong, line)
+		}
+	}
+	return SNVlong, scanner.Err()
+}
+
+// writeLines function will write lines in a slice in to out file
+func writeLines(lines []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// getADcount function will return ref and alt read count of a sample
+func getADcount(genoField string) (float64, float64) {
+	if genoField == "." || genoField == "./." {
+		return 0.0, 0.0
+	}
+	genoSlice := strings.Split(genoField, ":")
+	adSlice := strings.Split(genoSlice[2], ",")
+	refCount, _ := strconv.ParseFloat(adSlice[0], 64)
+	altCount, _ := strconv.ParseFloat(adSlice[1], 64)
+	return refCount, altCount
+}
+
+// getChrSNV function will read allele counts of both bulks, polarized to the
+// allele of parent v3, and group SNVs by chromosome in order of position
+func getChrSNV(vcfLines []string) ([]string, map[string][]snvCount) {
+	chrSlice := []string{}
+	chrMap := map[string][]snvCount{}
+	for _, valLine := range vcfLines {
+		lineSlice := strings.Fields(valLine)
+		idSlice := strings.Split(lineSlice[0], "_")
+		pos, _ := strconv.Atoi(idSlice[1])
+		wtRef, wtAlt := getADcount(lineSlice[3])
+		mtRef, mtAlt := getADcount(lineSlice[4])
+		paIndex, _ := strconv.ParseFloat(lineSlice[9], 64)
+		if paIndex < 0.0 {
+			wtRef, wtAlt = wtAlt, wtRef
+			mtRef, mtAlt = mtAlt, mtRef
+		}
+		if _, ok := chrMap[idSlice[0]]; !ok {
+			chrSlice = append(chrSlice, idSlice[0])
+		}
+		chrMap[idSlice[0]] = append(chrMap[idSlice[0]], snvCount{id: lineSlice[0], pos: pos, wtAlt: wtAlt, wtRef: wtRef, mtAlt: mtAlt, mtRef: mtRef})
+	}
+	for _, valChr := range chrSlice {
+		snvSlice := chrMap[valChr]
+		sort.Slice(snvSlice, func(i, j int) bool { return snvSlice[i].pos < snvSlice[j].pos })
+	}
+	return chrSlice, chrMap
+}
+
+// calRecomb function will return recombination fraction between two positions
+// using Haldane map function
+func calRecomb(dist int, cmPerMb float64) float64 {
+	morgan := math.Abs(float64(dist)) / 1000000.0 * cmPerMb / 100.0
+	return 0.5 * (1.0 - math.Exp(-2.0*morgan))
+}
+
+// calLogLike function will return log likelihood of bulk allele counts with
+// given delta SNP index, binomial constants are omitted
+func calLogLike(snv snvCount, delta float64) float64 {
+	wtFreq := math.Min(math.Max(0.5+delta/2.0, 1e-6), 1.0-1e-6)
+	mtFreq := math.Min(math.Max(0.5-delta/2.0, 1e-6), 1.0-1e-6)
+	return snv.wtAlt*math.Log(wtFreq) + snv.wtRef*math.Log(1.0-wtFreq) +
+		snv.mtAlt*math.Log(mtFreq) + snv.mtRef*math.Log(1.0-mtFreq)
+}
+
+// calPosterior function will do scaled forward-backward algorithm on SNVs of a
+// chromosome and store posterior of three states (unlinked, linked+, linked-)
+func calPosterior(snvSlice []snvCount, cmPerMb float64, freqShift float64, linkProb float64) {
+	numSnv := len(snvSlice)
+	stateDelta := []float64{0.0, freqShift, -freqShift}
+	emitSlice := make([][]float64, numSnv)
+	for i, valSnv := range snvSlice {
+		logSlice := []float64{}
+		maxLog := math.Inf(-1)
+		for _, valDelta := range stateDelta {
+			logLike := calLogLike(valSnv, valDelta)
+			logSlice = append(logSlice, logLike)
+			maxLog = math.Max(maxLog, logLike)
+		}
+		emitSlice[i] = make([]float64, 3)
+		for k := range logSlice {
+			emitSlice[i][k] = math.Exp(logSlice[k] - maxLog)
+		}
+	}
+
+	transition := func(i int, from int, to int) float64 {
+		recomb := calRecomb(snvSlice[i].pos-snvSlice[i-1].pos, cmPerMb)
+		if from == to {
+			return 1.0 - recomb
+		}
+		return recomb / 2.0
+	}
+
+	forward := make([][]float64, numSnv)
+	for i := 0; i < numSnv; i++ {
+		forward[i] = make([]float64, 3)
+		scale := 0.0
+		for k := 0; k < 3; k++ {
+			if i == 0 {
+				prior := linkProb / 2.0
+				if k == 0 {
+					prior = 1.0 - linkProb
+				}
+				forward[i][k] = prior * emitSlice[i][k]
+			} else {
+				for l := 0; l < 3; l++ {
+					forward[i][k] += forward[i-1][l] * transition(i, l, k)
+				}
+				forward[i][k] *= emitSlice[i][k]
+			}
+			scale += forward[i][k]
+		}
+		for k := 0; k < 3; k++ {
+			forward[i][k] /= scale
+		}
+	}
+
+	backward := make([][]float64, numSnv)
+	backward[numSnv-1] = []float64{1.0, 1.0, 1.0}
+	for i := numSnv - 2; i >= 0; i-- {
+		backward[i] = make([]float64, 3)
+		scale := 0.0
+		for k := 0; k < 3; k++ {
+			for l := 0; l < 3; l++ {
+				backward[i][k] += transition(i+1, k, l) * emitSlice[i+1][l] * backward[i+1][l]
+			}
+			scale += backward[i][k]
+		}
+		for k := 0; k < 3; k++ {
+			backward[i][k] /= scale
+		}
+	}
+
+	for i := range snvSlice {
+		postSum := 0.0
+		postSlice := make([]float64, 3)
+		for k := 0; k < 3; k++ {
+			postSlice[k] = forward[i][k] * backward[i][k]
+			postSum += postSlice[k]
+		}
+		for k := 0; k < 3; k++ {
+			postSlice[k] /= postSum
+		}
+		snvSlice[i].postSlice = postSlice
+	}
+}
+
+// getCausalLocation function will calculate likelihood of each SNV in a linked
+// region as causal location, with delta SNP index decaying by recombination
+// away from it, and return the most likely location and credible interval
+func getCausalLocation(regionSlice []snvCount, cmPerMb float64, freqShift float64, credProb float64) []int {
+	linkPos := 0.0
+	linkNeg := 0.0
+	for _, valSnv := range regionSlice {
+		linkPos += valSnv.postSlice[1]
+		linkNeg += valSnv.postSlice[2]
+	}
+	signShift := freqShift
+	if linkNeg > linkPos {
+		signShift = -freqShift
+	}
+
+	logSlice := []float64{}
+	maxLog := math.Inf(-1)
+	maxIdx := 0
+	for c, causalSnv := range regionSlice {
+		logLike := 0.0
+		for _, valSnv := range regionSlice {
+			recomb := calRecomb(valSnv.pos-causalSnv.pos, cmPerMb)
+			logLike += calLogLike(valSnv, signShift*(1.0-2.0*recomb))
+		}
+		logSlice = append(logSlice, logLike)
+		if logLike > maxLog {
+			maxLog = logLike
+			maxIdx = c
+		}
+	}
+
+	postSum := 0.0
+	postSlice := []float64{}
+	for _, valLog := range logSlice {
+		postSlice = append(postSlice, math.Exp(valLog-maxLog))
+		postSum += math.Exp(valLog - maxLog)
+	}
+	lowIdx := 0
+	highIdx := len(regionSlice) - 1
+	cumPost := 0.0
+	for c, valPost := range postSlice {
+		cumPost += valPost / postSum
+		if cumPost < (1.0-credProb)/2.0 {
+			lowIdx = c + 1
+		}
+		if cumPost >= 1.0-(1.0-credProb)/2.0 && c < highIdx {
+			highIdx = c
+		}
+	}
+	if lowIdx > highIdx {
+		lowIdx = highIdx
+	}
+	return []int{regionSlice[maxIdx].pos, regionSlice[lowIdx].pos, regionSlice[highIdx].pos}
+}
+
+// main function
+func main() {
+	flag.Parse()
+
+	fmt.Println("[", time.Now(), "] ", "Program start ...")
+
+	vcfLines, err := getSNVlong(*passFile)
+	if err != nil {
+		log.Fatalf("read input pass file: %s", err)
+	}
+
+	chrSlice, chrMap := getChrSNV(vcfLines)
+
+	postLines := []string{"#ID\tCHR\tPOS\tpost_unlinked\tpost_linked_pos\tpost_linked_neg\tpost_linked"}
+	qtlLines := []string{"#CHR\tSTART\tEND\tnum_SNVs\tmax_post_linked\tcausal_pos\tCI_low\tCI_high"}
+	for _, valChr := range chrSlice {
+		snvSlice := chrMap[valChr]
+		calPosterior(snvSlice, *cmPerMb, *freqShift, *linkProb)
+
+		regionStart := -1
+		for i, valSnv := range snvSlice {
+			postLink := valSnv.postSlice[1] + valSnv.postSlice[2]
+			postSlice := []string{valSnv.id, valChr, strconv.Itoa(valSnv.pos)}
+			for _, valPost := range valSnv.postSlice {
+				postSlice = append(postSlice, strconv.FormatFloat(valPost, 'f', 4, 64))
+			}
+			postSlice = append(postSlice, strconv.FormatFloat(postLink, 'f', 4, 64))
+			postLines = append(postLines, strings.Join(postSlice, "\t"))
+
+			//linked region is a run of SNVs with posterior of linkage > 0.5
+			if postLink > 0.5 && regionStart < 0 {
+				regionStart = i
+			}
+			if regionStart >= 0 && (postLink <= 0.5 || i == len(snvSlice)-1) {
+				regionEnd := i
+				if postLink <= 0.5 {
+					regionEnd = i - 1
+				}
+				regionSlice := snvSlice[regionStart : regionEnd+1]
+				maxPost := 0.0
+				for _, valRegion := range regionSlice {
+					maxPost = math.Max(maxPost, valRegion.postSlice[1]+valRegion.postSlice[2])
+				}
+				causalSlice := getCausalLocation(regionSlice, *cmPerMb, *freqShift, *credProb)
+				qtlSlice := []string{
+					valChr, strconv.Itoa(regionSlice[0].pos), strconv.Itoa(regionSlice[len(regionSlice)-1].pos),
+					strconv.Itoa(len(regionSlice)), strconv.FormatFloat(maxPost, 'f', 4, 64),
+					strconv.Itoa(causalSlice[0]), strconv.Itoa(causalSlice[1]), strconv.Itoa(causalSlice[2]),
+				}
+				qtlLines = append(qtlLines, strings.Join(qtlSlice, "\t"))
+				regionStart = -1
+			}
+		}
+	}
+	fmt.Println("Total number of linked regions is: ", len(qtlLines)-1)
+
+	if err := writeLines(postLines, *outFile); err != nil {
+		log.Fatalf("write posterior of linkage: %s", err)
+	}
+
+	if err := writeLines(qtlLines, *qtlFile); err != nil {
+		log.Fatalf("write causal location: %s", err)
+	}
+
+	fmt.Println("[", time.Now(), "] ", "Program end ...")
+}
@@ -0,0 +1,320 @@
+// This tool is used to run index, simulation and sliding windows for several
+// traits in one run, with one bulk pair per trait jointly called with the
+// two parents (v3, CE) in a vcf file. Vcf parsing and parental depth filter
+// are shared by all traits, the vcf of each trait is run through the calAF,
+// DpSim and sliding windows stage binaries, and QTLs of all traits are merged
+// into one table with overlapping traits.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// declare command arguments
+var (
+	vcfFile    = flag.String("in", "", "Input vcf file with parents (v3, CE) and bulks of all traits")
+	traitFile  = flag.String("traits", "", "Input trait file: trait, high bulk sample and low bulk sample")
+	chrFile    = flag.String("chr", "", "Input chromosome file")
+	outPrefix  = flag.String("out", "", "Output prefix of per-trait files and QTL overlap table")
+	popStruct  = flag.String("p", "", "Population struction: RIL or F2")
+	numIndvl   = flag.Int("n", 0, "Number of individuals in each bulk")
+	rep        = flag.Int("r", 0, "Number of replication in simulation")
+	filterVal  = flag.Float64("f", 0.3, "Filter value")
+	cpus       = flag.Int("c", 1, "Number of working CPUs")
+	windowSize = flag.Int("w", 20000000, "Window size, default (20mb) as in sliding windows stage")
+	shiftSize  = flag.Int("s", 20000, "Shift size, default (20kb)")
+	binDir     = flag.String("bin", "", "Directory of stage binaries (vcfCalAFindexGo, vcfDpSimSigConCurGo, vcfSlidingWindowsIndexConCurGo), default PATH")
+	afArgs     = flag.String("afArgs", "", "Extra arguments of calAF stage, e.g. \"-bias genome -ploidy 4\"")
+	simArgs    = flag.String("simArgs", "", "Extra arguments of DpSim stage, e.g. \"-nHigh 30 -nLow 50 -e 0.01 -grid 20\"")
+	winArgs    = flag.String("winArgs", "", "Extra arguments of sliding windows stage, e.g. \"-minSNV 20 -map map.txt\"")
+)
+
+// getSNVheader will read header information (## lines) from vcf file and
+// return a slice type
+func getSNVheader(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^##")
+	scanner := bufio.NewScanner(file)
+	headerStr := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			headerStr = append(headerStr, line)
+		}
+	}
+	return headerStr, scanner.Err()
+}
+
+// getLines function reads lines from file and return a slice,
+// with each element representing each line
+func getLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^[^#]")
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// getSNVtitle function will read sample names from title line of vcf and
+// return a map type with sample name as key and column as value
+func getSNVtitle(path string) (map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^#CHROM")
+	scanner := bufio.NewScanner(file)
+	titleMap := map[string]int{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			for idxTitle, valTitle := range strings.Fields(line) {
+				if idxTitle > 8 {
+					titleMap[valTitle] = idxTitle
+				}
+			}
+		}
+	}
+	return titleMap, scanner.Err()
+}
+
+// writeLines function will write lines in a slice in to out file
+func writeLines(lines []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// getADcount function will return ref and alt read count of a sample
+func getADcount(genoField string) (int, int) {
+	if genoField == "." || genoField == "./." {
+		return 0, 0
+	}
+	genoSlice := strings.Split(genoField, ":")
+	adSlice := strings.Split(genoSlice[2], ",")
+	refCount, _ := strconv.Atoi(adSlice[0])
+	altCount, _ := strconv.Atoi(adSlice[1])
+	return refCount, altCount
+}
+
+// getParentSNV function will keep bi-allelic SNVs with depth > 9 in both
+// parents, which are shared by all traits
+func getParentSNV(vcfLines []string) [][]string {
+	parentSlice := [][]string{}
+	for _, valLine := range vcfLines {
+		lineSlice := strings.Fields(valLine)
+		if strings.Contains(lineSlice[4], ",") {
+			continue
+		}
+		v3Ref, v3Alt := getADcount(lineSlice[9])
+		ceRef, ceAlt := getADcount(lineSlice[10])
+		if v3Ref+v3Alt <= 9 || ceRef+ceAlt <= 9 {
+			continue
+		}
+		parentSlice = append(parentSlice, lineSlice)
+	}
+	return parentSlice
+}
+
+// getTraitVcf function will keep SNVs with depth > 9 in high and low bulks of
+// a trait, and return lines with samples in the order of v3, CE, WT (high) and
+// MT (low) as preprocessed vcf
+func getTraitVcf(parentSlice [][]string, highCol int, lowCol int) []string {
+	traitLines := []string{}
+	for _, lineSlice := range parentSlice {
+		highRef, highAlt := getADcount(lineSlice[highCol])
+		lowRef, lowAlt := getADcount(lineSlice[lowCol])
+		if highRef+highAlt <= 9 || lowRef+lowAlt <= 9 {
+			continue
+		}
+		newSnvSlice := append([]string{}, lineSlice[0:11]...)
+		newSnvSlice = append(newSnvSlice, lineSlice[highCol], lineSlice[lowCol])
+		traitLines = append(traitLines, strings.Join(newSnvSlice, "\t"))
+	}
+	return traitLines
+}
+
+// runStage function will run a stage binary in binDir (or in PATH if binDir
+// is empty) with given arguments and extra arguments separated by spaces
+func runStage(binDir string, name string, args []string, extraArgs string) error {
+	args = append(args, strings.Fields(extraArgs)...)
+	cmd := exec.Command(filepath.Join(binDir, name), args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// main function
+func main() {
+	flag.Parse()
+
+	fmt.Println("[", time.Now(), "] ", "Program start ...")
+
+	vcfHeader, err := getSNVheader(*vcfFile)
+	if err != nil {
+		log.Fatalf("read vcf header: %s", err)
+	}
+
+	titleMap, err := getSNVtitle(*vcfFile)
+	if err != nil {
+		log.Fatalf("read vcf title: %s", err)
+	}
+
+	traitLines, err := getLines(*traitFile)
+	if err != nil {
+		log.Fatalf("read input trait file: %s", err)
+	}
+
+	vcfLines, err := getLines(*vcfFile)
+	if err != nil {
+		log.Fatalf("read input vcf file: %s", err)
+	}
+
+	parentSlice := getParentSNV(vcfLines)
+	fmt.Println("Total number of SNVs passing parental depth is: ", len(parentSlice))
+
+	vcfTitle := []string{"#CHROM", "POS", "ID", "REF", "ALT", "QUAL", "FILTER", "INFO", "FORMAT", "v3", "CE2_1", "WT", "MT"}
+
+	//check all trait lines before running stages
+	for _, valTrait := range traitLines {
+		traitField := strings.Fields(valTrait)
+		if len(traitField) < 3 {
+			log.Fatalf("trait line needs trait, high bulk sample and low bulk sample: %s", valTrait)
+		}
+		_, okHigh := titleMap[traitField[1]]
+		_, okLow := titleMap[traitField[2]]
+		if !okHigh || !okLow {
+			log.Fatalf("bulk samples of trait %s not found in vcf title", traitField[0])
+		}
+	}
+
+	traitSlice := []string{}
+	traitQTLmap := map[string][][]string{}
+	for _, valTrait := range traitLines {
+		traitField := strings.Fields(valTrait)
+		highCol := titleMap[traitField[1]]
+		lowCol := titleMap[traitField[2]]
+		fmt.Println("[", time.Now(), "] ", "Start trait: ", traitField[0])
+
+		traitPrefix := *outPrefix + "." + traitField[0]
+		traitVcf := append([]string{}, vcfHeader...)
+		traitVcf = append(traitVcf, strings.Join(vcfTitle, "\t"))
+		traitVcf = append(traitVcf, getTraitVcf(parentSlice, highCol, lowCol)...)
+		if err := writeLines(traitVcf, traitPrefix+".vcf"); err != nil {
+			log.Fatalf("write vcf of trait %s: %s", traitField[0], err)
+		}
+
+		afStageArgs := []string{
+			"-in", traitPrefix + ".vcf", "-pass", traitPrefix + ".pass.txt",
+			"-fail", traitPrefix + ".fail.txt", "-skip", traitPrefix + ".skip.txt",
+		}
+		if err := runStage(*binDir, "vcfCalAFindexGo", afStageArgs, *afArgs); err != nil {
+			log.Fatalf("run calAF stage of trait %s: %s", traitField[0], err)
+		}
+
+		simStageArgs := []string{
+			"-in", traitPrefix + ".pass.txt", "-dp", traitPrefix + ".dp.txt", "-out", traitPrefix + ".index.txt",
+			"-p", *popStruct, "-n", strconv.Itoa(*numIndvl), "-r", strconv.Itoa(*rep),
+			"-f", strconv.FormatFloat(*filterVal, 'f', -1, 64), "-c", strconv.Itoa(*cpus),
+		}
+		if err := runStage(*binDir, "vcfDpSimSigConCurGo", simStageArgs, *simArgs); err != nil {
+			log.Fatalf("run DpSim stage of trait %s: %s", traitField[0], err)
+		}
+
+		winStageArgs := []string{
+			"-chr", *chrFile, "-vcf", traitPrefix + ".index.txt", "-out", traitPrefix + ".bin.txt",
+			"-w", strconv.Itoa(*windowSize), "-s", strconv.Itoa(*shiftSize), "-c", strconv.Itoa(*cpus),
+			"-qtlOut", traitPrefix + ".qtl.txt",
+		}
+		if err := runStage(*binDir, "vcfSlidingWindowsIndexConCurGo", winStageArgs, *winArgs); err != nil {
+			log.Fatalf("run sliding windows stage of trait %s: %s", traitField[0], err)
+		}
+
+		//QTLs are called by sliding windows stage, as chr, start, end, peak
+		//position and peak AveIdx_F2
+		qtlLines, err := getLines(traitPrefix + ".qtl.txt")
+		if err != nil {
+			log.Fatalf("read QTLs of trait %s: %s", traitField[0], err)
+		}
+		traitQTL := [][]string{}
+		for _, valQTL := range qtlLines {
+			traitQTL = append(traitQTL, strings.Fields(valQTL)[:5])
+		}
+
+		traitSlice = append(traitSlice, traitField[0])
+		traitQTLmap[traitField[0]] = traitQTL
+		fmt.Println("Total number of QTLs of trait ", traitField[0], " is: ", len(traitQTLmap[traitField[0]]))
+	}
+	//combine QTLs of all traits with overlapping traits
+	qtlLines := []string{"#TRAIT\tCHR\tSTART\tEND\tpeak_pos\tpeak_AveIdx_F2\toverlap_traits"}
+	for _, valTrait := range traitSlice {
+		for _, valQTL := range traitQTLmap[valTrait] {
+			qtlStart, _ := strconv.Atoi(valQTL[1])
+			qtlEnd, _ := strconv.Atoi(valQTL[2])
+			overlapSlice := []string{}
+			for _, otherTrait := range traitSlice {
+				if otherTrait == valTrait {
+					continue
+				}
+				for _, otherQTL := range traitQTLmap[otherTrait] {
+					otherStart, _ := strconv.Atoi(otherQTL[1])
+					otherEnd, _ := strconv.Atoi(otherQTL[2])
+					if otherQTL[0] == valQTL[0] && otherStart <= qtlEnd && otherEnd >= qtlStart {
+						overlapSlice = append(overlapSlice, otherTrait)
+						break
+					}
+				}
+			}
+			overlapStr := "."
+			if len(overlapSlice) > 0 {
+				overlapStr = strings.Join(overlapSlice, ",")
+			}
+			qtlLine := []string{valTrait}
+			qtlLine = append(qtlLine, valQTL...)
+			qtlLine = append(qtlLine, overlapStr)
+			qtlLines = append(qtlLines, strings.Join(qtlLine, "\t"))
+		}
+	}
+
+	if err := writeLines(qtlLines, *outPrefix+".qtl_overlap.txt"); err != nil {
+		log.Fatalf("write QTL overlap table: %s", err)
+	}
+
+	fmt.Println("[", time.Now(), "] ", "Program end ...")
+}
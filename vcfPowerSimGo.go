@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leesper/go_rng"
+)
+
+// declare command arguments
+var (
+	outFile   = flag.String("out", "", "Output power table of designs")
+	popStruct = flag.String("p", "F2", "Population struction: RIL or F2")
+	bulkList  = flag.String("n", "20,50,100", "Comma separated numbers of individuals in each bulk, or high:low pairs of unequal bulks (e.g. 30:50)")
+	dpList    = flag.String("dp", "10,20,40", "Comma separated sequencing depths of each bulk")
+	shiftList = flag.String("shift", "0.2,0.4", "Comma separated QTL effects: allele frequency difference between high and low bulk")
+	snvList   = flag.String("snv", "10,50", "Comma separated numbers of SNVs in each window")
+	rep       = flag.Int("r", 1000, "Number of replication in threshold simulation")
+	trial     = flag.Int("t", 200, "Number of simulated windows of each design for power and false-positive rate")
+	filterVal = flag.Float64("f", 0.3, "Filter value")
+	errRate   = flag.Float64("e", 0.0, "Per-base sequencing error rate in simulation, default 0")
+	overDisp  = flag.Float64("od", 0.0, "Beta-binomial overdispersion of read counts in simulation, default 0 (binomial)")
+	refBias   = flag.Float64("bias", 0.5, "Alt read ratio of heterozygous sites in null model (reference bias), default 0.5 (no bias)")
+	ploidy    = flag.Int("ploidy", 2, "Ploidy of the population, e.g. 2, 4 or 6")
+	inherit   = flag.String("inherit", "poly", "Inheritance of polyploids: poly (polysomic, autopolyploid) or di (disomic, allopolyploid)")
+	homeo     = flag.Bool("homeo", false, "Reads of homeologous subgenomes map to SNVs of disomic polyploids (ref allele)")
+	cpus      = flag.Int("c", 1, "Number of workding CPUs")
+)
+
+// writeLines function will write lines to the given file
+func writeLines(lines []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// getIntList function will parse a comma separated list of integers
+func getIntList(valList string) ([]int, error) {
+	intSlice := []int{}
+	for _, val := range strings.Split(valList, ",") {
+		intVal, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return nil, err
+		}
+		intSlice = append(intSlice, intVal)
+	}
+	return intSlice, nil
+}
+
+// getFloatList function will parse a comma separated list of floats
+func getFloatList(valList string) ([]float64, error) {
+	floatSlice := []float64{}
+	for _, val := range strings.Split(valList, ",") {
+		floatVal, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return nil, err
+		}
+		floatSlice = append(floatSlice, floatVal)
+	}
+	return floatSlice, nil
+}
+
+// genotype function will randomly get genotype given population struction and
+// frequency of the allele in the bulk (0.5 without QTL). Polyploids follow
+// the depth simulation, polysomic gametes carry ploidy/2 alleles drawn without
+// replacement from the F1, and a QTL is modelled by gametes carrying only the
+// selected allele with probability 2*|alleleFreq-0.5|
+func genotype(popStrut string, alleleFreq float64, ploidy int, inherit string, homeo bool) float64 {
+	count := 0.0
+	if ploidy > 2 && inherit == "di" {
+		count = genotype(popStrut, alleleFreq, 2, inherit, homeo)
+		if homeo {
+			count = count * 2.0 / float64(ploidy)
+		}
+	} else if ploidy > 2 && popStrut != "RIL" {
+		for i := 1; i <= 2; i++ {
+			randge := rng.NewUniformGenerator(time.Now().UnixNano())
+			if randge.Float64() < 2.0*math.Abs(alleleFreq-0.5) {
+				if alleleFreq > 0.5 {
+					count += 0.5
+				}
+				continue
+			}
+			remainAlt := ploidy / 2
+			remainTotal := ploidy
+			for k := 1; k <= ploidy/2; k++ {
+				frq := randge.Float64()
+				if frq < float64(remainAlt)/float64(remainTotal) {
+					count += 1.0 / float64(ploidy)
+					remainAlt--
+				}
+				remainTotal--
+			}
+		}
+	} else if popStrut == "RIL" {
+		randge := rng.NewUniformGenerator(time.Now().UnixNano())
+		frq := randge.Float64()
+
+		if frq <= alleleFreq {
+			count = 1.0
+		} else {
+			count = 0.0
+		}
+	} else {
+		for i := 1; i <= 2; i++ {
+			randge := rng.NewUniformGenerator(time.Now().UnixNano())
+			frq := randge.Float64()
+			if frq <= alleleFreq {
+				count += 0.5
+			}
+		}
+	}
+	return count
+}
+
+// getBackgroundIndex function will return expected index of unlinked SNVs
+// given ploidy and inheritance, as in the depth simulation
+func getBackgroundIndex(ploidy int, inherit string, homeo bool) float64 {
+	if ploidy > 2 && inherit == "di" && homeo {
+		return 1.0 / float64(ploidy)
+	}
+	return 0.5
+}
+
+// calIndvlGeno function will calculate individual genotype using randomly
+// generated genotype
+func calIndvlGeno(numIndvl int, popStrut string, alleleFreq float64, ploidy int, inherit string, homeo bool) float64 {
+	genoTotal := 0.0
+	for j := 1; j <= numIndvl; j++ {
+		genoTotal += genotype(popStrut, alleleFreq, ploidy, inherit, homeo)
+	}
+	indvlGeno := genoTotal / float64(int64(numIndvl))
+	return indvlGeno
+}
+
+// calIndvlIndex function will calculate indeividual index using binomial
+// (beta-binomial with overdispersion) distribution with given depth and
+// expected genotype ratio, shifted by reference bias and sequencing error as
+// in the depth simulation
+func calIndvlIndex(dp int, ratioGeno float64, errRate float64, overDisp float64, refBias float64) float64 {
+	biasWeight := refBias / (1.0 - refBias)
+	ratioGeno = biasWeight * ratioGeno / (biasWeight*ratioGeno + 1.0 - ratioGeno)
+	ratioRead := ratioGeno*(1.0-errRate) + (1.0-ratioGeno)*errRate
+	if overDisp > 0.0 && ratioRead > 0.0 && ratioRead < 1.0 {
+		betage := rng.NewBetaGenerator(time.Now().UnixNano())
+		ratioRead = betage.Beta(ratioRead*(1.0-overDisp)/overDisp, (1.0-ratioRead)*(1.0-overDisp)/overDisp)
+	}
+	binomalge := rng.NewBinomialGenerator(time.Now().UnixNano())
+	indvlIndex := binomalge.Binomial(int64(dp), ratioRead)
+	indvlIndexAve := float64(indvlIndex) / float64(dp)
+	return indvlIndexAve
+}
+
+// getPercentile function will get the low or high percentile of a sorted slice
+// in the same way as the depth simulation
+func getPercentile(sortSlice []float64, prob float64, high bool) float64 {
+	sortLen := float64(len(sortSlice))
+	if high {
+		if math.Ceil(prob*sortLen) < sortLen {
+			return sortSlice[int(math.Ceil(prob*sortLen))]
+		}
+		return sortSlice[len(sortSlice)-1]
+	}
+	if math.Floor(prob*sortLen) > 0.0 {
+		return sortSlice[int(math.Floor(prob*sortLen))]
+	}
+	return sortSlice[0]
+}
+
+// simIndex function will do QTL simulation with given times of replication and
+// return the 95% high threshold of delta index
+func simIndex(numHigh int, numLow int, dp int, rep int, filterVal float64, popStrut string, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool) float64 {
+	delIndvlIndexSlice := []float64{}
+	for k := 1; k <= rep; k++ {
+		wtIndvlIndex := calIndvlIndex(dp, calIndvlGeno(numHigh, popStrut, 0.5, ploidy, inherit, homeo), errRate, overDisp, refBias)
+		mtIndvlIndex := calIndvlIndex(dp, calIndvlGeno(numLow, popStrut, 0.5, ploidy, inherit, homeo), errRate, overDisp, refBias)
+		if wtIndvlIndex >= filterVal || mtIndvlIndex >= filterVal {
+			delIndvlIndexSlice = append(delIndvlIndexSlice, wtIndvlIndex-mtIndvlIndex)
+		}
+	}
+	if len(delIndvlIndexSlice) == 0 {
+		return 1.0
+	}
+	sort.Float64s(delIndvlIndexSlice)
+	return getPercentile(delIndvlIndexSlice, 0.975, true)
+}
+
+// simWindow function will simulate average delta index of a window. The bulk
+// genotypes are drawn once per window, as SNVs in a window are linked, and
+// reads of each SNV are drawn at the given depth. The high bulk carries the
+// allele with frequency 0.5+shift/2 and the low bulk 0.5-shift/2
+func simWindow(numHigh int, numLow int, dp int, numSnv int, shift float64, filterVal float64, popStrut string, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool) (float64, bool) {
+	wtRatioGeno := calIndvlGeno(numHigh, popStrut, 0.5+shift/2.0, ploidy, inherit, homeo)
+	mtRatioGeno := calIndvlGeno(numLow, popStrut, 0.5-shift/2.0, ploidy, inherit, homeo)
+	delIndexSum := 0.0
+	numVcf := 0
+	for i := 1; i <= numSnv; i++ {
+		wtIndvlIndex := calIndvlIndex(dp, wtRatioGeno, errRate, overDisp, refBias)
+		mtIndvlIndex := calIndvlIndex(dp, mtRatioGeno, errRate, overDisp, refBias)
+		if wtIndvlIndex >= filterVal || mtIndvlIndex >= filterVal {
+			delIndexSum += wtIndvlIndex - mtIndvlIndex
+			numVcf++
+		}
+	}
+	if numVcf == 0 {
+		return 0.0, false
+	}
+	return delIndexSum / float64(numVcf), true
+}
+
+// calPower function will estimate power of a design as the fraction of QTL
+// windows above the 95% threshold, and false-positive rate as the fraction of
+// windows without QTL above the threshold
+func calPower(numHigh int, numLow int, dp int, shift float64, numSnv int, p95H float64, trial int, filterVal float64, popStrut string, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool) (float64, float64) {
+	numPower := 0
+	numFalse := 0
+	for k := 1; k <= trial; k++ {
+		if aveIndex, ok := simWindow(numHigh, numLow, dp, numSnv, shift, filterVal, popStrut, errRate, overDisp, refBias, ploidy, inherit, homeo); ok && aveIndex > p95H {
+			numPower++
+		}
+		if aveIndex, ok := simWindow(numHigh, numLow, dp, numSnv, 0.0, filterVal, popStrut, errRate, overDisp, refBias, ploidy, inherit, homeo); ok && aveIndex > p95H {
+			numFalse++
+		}
+	}
+	return float64(numPower) / float64(trial), float64(numFalse) / float64(trial)
+}
+
+// getBulkSize function will get numbers of individuals in high and low bulk
+// from a bulk size (n) or high:low pair
+func getBulkSize(bulkStr string) (int, int, error) {
+	bulkSlice := strings.Split(strings.TrimSpace(bulkStr), ":")
+	numHigh, err := strconv.Atoi(bulkSlice[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(bulkSlice) == 1 {
+		return numHigh, numHigh, nil
+	}
+	numLow, err := strconv.Atoi(bulkSlice[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return numHigh, numLow, nil
+}
+
+// worker function makes working pools to simulate thresholds and power of
+// designs, each job is a design line of bulk size, depth, shift and SNVs
+func worker(rep int, trial int, filterVal float64, popStrut string, errRate float64, overDisp float64, refBias float64, ploidy int, inherit string, homeo bool, jobs <-chan string, results chan<- string) {
+	for j := range jobs {
+		designSlice := strings.Split(j, "\t")
+		numHigh, numLow, _ := getBulkSize(designSlice[0])
+		dp, _ := strconv.Atoi(designSlice[1])
+		shift, _ := strconv.ParseFloat(designSlice[2], 64)
+		numSnv, _ := strconv.Atoi(designSlice[3])
+
+		p95H := simIndex(numHigh, numLow, dp, rep, filterVal, popStrut, errRate, overDisp, refBias, ploidy, inherit, homeo)
+		power, falseRate := calPower(numHigh, numLow, dp, shift, numSnv, p95H, trial, filterVal, popStrut, errRate, overDisp, refBias, ploidy, inherit, homeo)
+		powerSlice := []string{
+			popStrut, j, strconv.FormatFloat(p95H, 'f', 2, 64),
+			strconv.FormatFloat(power, 'f', 4, 64), strconv.FormatFloat(falseRate, 'f', 4, 64),
+		}
+		results <- strings.Join(powerSlice, "\t")
+	}
+}
+
+// maxParallelism function will return working number of CPUs, if requested number
+// of CPUs is greater than available CPUs, use available CPUs (machine CPUs)
+func maxParallelism(cpus int) int {
+	maxProcs := runtime.GOMAXPROCS(cpus)
+	numCPU := runtime.NumCPU()
+	if maxProcs < numCPU {
+		return maxProcs
+	}
+	return numCPU
+}
+
+// main function
+func main() {
+	flag.Parse()
+
+	fmt.Println("[", time.Now(), "] ", "Program start ...")
+
+	bulkSlice := strings.Split(*bulkList, ",")
+	for _, valBulk := range bulkSlice {
+		if _, _, err := getBulkSize(valBulk); err != nil {
+			log.Fatalf("parse bulk sizes: %s", err)
+		}
+	}
+	dpSlice, err := getIntList(*dpList)
+	if err != nil {
+		log.Fatalf("parse depths: %s", err)
+	}
+	shiftSlice, err := getFloatList(*shiftList)
+	if err != nil {
+		log.Fatalf("parse QTL effects: %s", err)
+	}
+	snvSlice, err := getIntList(*snvList)
+	if err != nil {
+		log.Fatalf("parse SNVs per window: %s", err)
+	}
+
+	designSlice := []string{}
+	for _, valBulk := range bulkSlice {
+		for _, valDp := range dpSlice {
+			for _, valShift := range shiftSlice {
+				for _, valSnv := range snvSlice {
+					designSlice = append(designSlice, strings.Join([]string{
+						strings.TrimSpace(valBulk), strconv.Itoa(valDp),
+						strconv.FormatFloat(valShift, 'f', -1, 64), strconv.Itoa(valSnv),
+					}, "\t"))
+				}
+			}
+		}
+	}
+	fmt.Println("Total number of designs is: ", len(designSlice))
+
+	//null model as in depth simulation, filter value is scaled with expected
+	//index of unlinked SNVs
+	if *refBias <= 0.0 || *refBias >= 1.0 {
+		log.Fatalf("reference bias %f is not between 0 and 1", *refBias)
+	}
+	if *ploidy < 2 || *ploidy%2 != 0 {
+		log.Fatalf("ploidy %d is not an even number of at least 2", *ploidy)
+	}
+	if *inherit != "poly" && *inherit != "di" {
+		log.Fatalf("unknown -inherit %s: poly or di", *inherit)
+	}
+	if bgIndex := getBackgroundIndex(*ploidy, *inherit, *homeo); bgIndex != 0.5 {
+		*filterVal = *filterVal * bgIndex / 0.5
+		fmt.Println("Filter value scaled to expected background index is: ", strconv.FormatFloat(*filterVal, 'f', 4, 64))
+	}
+
+	numThreads := maxParallelism(*cpus)
+	fmt.Println("Total available CPU number is: ", runtime.NumCPU())
+	fmt.Println("Working CPU number is: ", numThreads)
+
+	jobs := make(chan string, len(designSlice))
+	results := make(chan string, len(designSlice))
+
+	for w := 1; w <= numThreads; w++ {
+		go worker(*rep, *trial, *filterVal, *popStruct, *errRate, *overDisp, *refBias, *ploidy, *inherit, *homeo, jobs, results)
+	}
+
+	for _, valDesign := range designSlice {
+		jobs <- valDesign
+	}
+	close(jobs)
+
+	powerMap := map[string]string{}
+	for a := 1; a <= len(designSlice); a++ {
+		powerLine := <-results
+		powerLineSlice := strings.SplitN(powerLine, "\t", 6)
+		powerMap[strings.Join(powerLineSlice[1:5], "\t")] = powerLine
+	}
+
+	//keep designs in grid order
+	powerLines := []string{"#POP\tnBulk\tDP\tshift\tSNVs\tp95H\tpower\tFPR"}
+	for _, valDesign := range designSlice {
+		powerLines = append(powerLines, powerMap[valDesign])
+	}
+
+	if err := writeLines(powerLines, *outFile); err != nil {
+		log.Fatalf("write power table: %s", err)
+	}
+
+	fmt.Println("[", time.Now(), "] ", "Program end ...")
+}
@@ -11,8 +11,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,6 +22,14 @@ import (
 
 var vcfIn = flag.String("in", "", "Input vcf file")
 var vcfOut = flag.String("out", "", "Output vcf file")
+var mode = flag.String("mode", "parents", "Depth filter mode: parents, single (one reference parent) or bulk (no parent)")
+var refPa = flag.String("refParent", "v3", "Reference parent used in single mode: v3 or CE")
+var ems = flag.Bool("ems", false, "Keep only canonical EMS transitions (G>A, C>T)")
+var emsPa = flag.String("emsParent", "v3", "Non-mutagenized parent used in EMS filter: v3 or CE")
+var dpQuantile = flag.Float64("dpQuantile", 0.0, "Remove sites with depth above this quantile of depth of a sample, e.g. 0.99, default 0 (off)")
+var dpSD = flag.Float64("dpSD", 0.0, "Remove sites with depth above mean + k*SD of depth of a sample, default 0 (off)")
+var groupFile = flag.String("groups", "", "Input sample group file (sample name and role v3, MT, WT or CE in each line), read counts of samples in a role are summed. GT of combined samples is called from summed reads without GQ, so calAF -gt is not supported")
+var maskFile = flag.String("mask", "", "Input bed file of masked regions, SNVs inside are removed")
 
 // getSNVheader will read header information from vcf file and return a slice type
 func getSNVheader(path string) ([]string, error) {
@@ -61,6 +71,179 @@ func getSNVlong(path string) ([]string, error) {
 	return SNVlong, scanner.Err()
 }
 
+// getSNVtitle will read title line (#CHROM) from vcf file and return column
+// names as a slice type
+func getSNVtitle(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^#CHROM")
+	scanner := bufio.NewScanner(file)
+	titleSlice := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			titleSlice = strings.Fields(line)
+			break
+		}
+	}
+	return titleSlice, scanner.Err()
+}
+
+// getGroupCols function will read sample group file and return columns of
+// member samples of each role, in the order of v3, MT, WT and CE. Only roles
+// required by filter mode must be assigned, other roles may be empty
+func getGroupCols(path string, titleSlice []string, mode string, refParent string) ([][]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	roleIdx := map[string]int{"v3": 0, "MT": 1, "WT": 2, "CE": 3}
+	groupCols := [][]int{{}, {}, {}, {}}
+	header, _ := regexp.Compile("^[^#]")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineSlice := strings.Fields(line)
+		if !header.MatchString(line) || len(lineSlice) < 2 {
+			continue
+		}
+		idxRole, ok := roleIdx[lineSlice[1]]
+		if !ok {
+			return nil, fmt.Errorf("role %s of sample %s is not v3, MT, WT or CE", lineSlice[1], lineSlice[0])
+		}
+		sampleCol := -1
+		for idxTitle := 9; idxTitle < len(titleSlice); idxTitle++ {
+			if titleSlice[idxTitle] == lineSlice[0] {
+				sampleCol = idxTitle
+			}
+		}
+		if sampleCol < 0 {
+			return nil, fmt.Errorf("sample %s is not in vcf title", lineSlice[0])
+		}
+		groupCols[idxRole] = append(groupCols[idxRole], sampleCol)
+	}
+	requiredRoles := []string{"v3", "MT", "WT", "CE"}
+	switch mode {
+	case "bulk":
+		requiredRoles = []string{"MT", "WT"}
+	case "single":
+		requiredRoles = []string{refParent, "MT", "WT"}
+	}
+	for _, keyRole := range requiredRoles {
+		if len(groupCols[roleIdx[keyRole]]) == 0 {
+			return nil, fmt.Errorf("no sample is assigned to role %s", keyRole)
+		}
+	}
+	return groupCols, scanner.Err()
+}
+
+// sumCountField function will sum comma separated counts of member samples,
+// "." if no member has counts
+func sumCountField(countFields []string) string {
+	if len(countFields) == 0 {
+		return "."
+	}
+	sumSlice := []int{}
+	for _, valField := range countFields {
+		for idxCount, valCount := range strings.Split(valField, ",") {
+			intCount, _ := strconv.Atoi(valCount)
+			if idxCount >= len(sumSlice) {
+				sumSlice = append(sumSlice, 0)
+			}
+			sumSlice[idxCount] += intCount
+		}
+	}
+	sumStrSlice := []string{}
+	for _, valSum := range sumSlice {
+		sumStrSlice = append(sumStrSlice, strconv.Itoa(valSum))
+	}
+	return strings.Join(sumStrSlice, ",")
+}
+
+// combineGroups function will combine member samples of each role into one
+// sample by summing DP, AD, RO, QR, AO and QA. Genotype is called from summed
+// read counts, and GL is not kept. The new line has samples in the order of
+// v3, MT, WT and CE as raw vcf, roles without samples are "."
+func combineGroups(line string, groupCols [][]int) string {
+	lineField := strings.Fields(line)
+	formatSlice := strings.Split(lineField[8], ":")
+	newLineSlice := append([]string{}, lineField[0:9]...)
+	for _, valGroup := range groupCols {
+		memberSlice := [][]string{}
+		for _, valCol := range valGroup {
+			if valCol < len(lineField) && lineField[valCol] != "." && lineField[valCol] != "./." {
+				memberSlice = append(memberSlice, strings.Split(lineField[valCol], ":"))
+			}
+		}
+		if len(memberSlice) == 0 {
+			newLineSlice = append(newLineSlice, ".")
+			continue
+		}
+
+		newGenoSlice := []string{}
+		for idxFormat, valFormat := range formatSlice {
+			countFields := []string{}
+			for _, valMember := range memberSlice {
+				if idxFormat < len(valMember) && valMember[idxFormat] != "." {
+					countFields = append(countFields, valMember[idxFormat])
+				}
+			}
+			switch valFormat {
+			case "DP", "AD", "RO", "QR", "AO", "QA":
+				newGenoSlice = append(newGenoSlice, sumCountField(countFields))
+			default:
+				newGenoSlice = append(newGenoSlice, ".")
+			}
+		}
+
+		//call genotype from summed ref and alt read counts, the sample is
+		//missing if no member has AD
+		missingAD := false
+		for idxFormat, valFormat := range formatSlice {
+			if valFormat != "AD" {
+				continue
+			}
+			if newGenoSlice[idxFormat] == "." {
+				missingAD = true
+				continue
+			}
+			adSlice := strings.Split(newGenoSlice[idxFormat], ",")
+			refDp, _ := strconv.Atoi(adSlice[0])
+			altDp := 0
+			for _, valAlt := range adSlice[1:] {
+				intAlt, _ := strconv.Atoi(valAlt)
+				altDp += intAlt
+			}
+			newGTstr := "0/1"
+			switch {
+			case refDp == 0 && altDp == 0:
+				newGTstr = "."
+			case refDp == 0:
+				newGTstr = "1/1"
+			case altDp == 0:
+				newGTstr = "0/0"
+			}
+			for idxGT, valGT := range formatSlice {
+				if valGT == "GT" {
+					newGenoSlice[idxGT] = newGTstr
+				}
+			}
+		}
+		if missingAD {
+			newLineSlice = append(newLineSlice, ".")
+			continue
+		}
+		newLineSlice = append(newLineSlice, strings.Join(newGenoSlice, ":"))
+	}
+	return strings.Join(newLineSlice, "\t")
+}
+
 // getNewInfo function will modify INFO fileld of SNVs in vcf file and return
 // new INFO field into a string
 func getNewInfo(infoField string, infoIdx int) string {
@@ -77,7 +260,7 @@ func getNewInfo(infoField string, infoIdx int) string {
 // getNewGeno function will split geno information into single alleles
 func getNewGeno(genoField string, infoIdx int) string {
 	var newGeno string
-	if genoField == "." {
+	if genoField == "." || genoField == "./." {
 		newGeno = "."
 	} else {
 		genoElts := strings.Split(genoField, ":")
@@ -192,7 +375,7 @@ func getDPslice(line string) []int {
 	lineField := strings.Fields(line)
 	smSlice := []string{lineField[9], lineField[10], lineField[11], lineField[12]}
 	for _, valGeno := range smSlice {
-		if valGeno == "." {
+		if valGeno == "." || valGeno == "./." {
 			dpSlice = append(dpSlice, 0)
 		} else {
 			genoSlice := strings.Split(valGeno, ":")
@@ -209,6 +392,177 @@ func getDPslice(line string) []int {
 	return dpSlice
 }
 
+// getAltDPslice function will get alt allele depth for each sample, the order
+// of depth slice is the same as getDPslice
+func getAltDPslice(line string) []int {
+	altDpSlice := []int{}
+	lineField := strings.Fields(line)
+	smSlice := []string{lineField[9], lineField[10], lineField[11], lineField[12]}
+	for _, valGeno := range smSlice {
+		if valGeno == "." || valGeno == "./." {
+			altDpSlice = append(altDpSlice, 0)
+		} else {
+			genoSlice := strings.Split(valGeno, ":")
+			if genoSlice[2] == "." {
+				altDpSlice = append(altDpSlice, 0)
+			} else {
+				dpElts := strings.Split(genoSlice[2], ",")
+				altDp, _ := strconv.Atoi(dpElts[1])
+				altDpSlice = append(altDpSlice, altDp)
+			}
+		}
+	}
+	return altDpSlice
+}
+
+// passEMS function will check whether a vcf line is a canonical EMS transition
+// (G>A or C>T) which is absent in the non-mutagenized parent
+func passEMS(line string, emsParent string) bool {
+	lineField := strings.Fields(line)
+	refAllele := lineField[3]
+	altAllele := lineField[4]
+	if !(refAllele == "G" && altAllele == "A") && !(refAllele == "C" && altAllele == "T") {
+		return false
+	}
+	altDpSlice := getAltDPslice(line)
+	if emsParent == "CE" {
+		return altDpSlice[3] == 0
+	}
+	return altDpSlice[0] == 0
+}
+
+// passDPslice function will check depth of samples required by filter mode,
+// the order of depth slice is v3, MT, WT and CE
+func passDPslice(dpSlice []int, mode string, refParent string) bool {
+	dpIdxSlice := []int{0, 1, 2, 3}
+	switch mode {
+	case "bulk":
+		dpIdxSlice = []int{1, 2}
+	case "single":
+		if refParent == "CE" {
+			dpIdxSlice = []int{1, 2, 3}
+		} else {
+			dpIdxSlice = []int{0, 1, 2}
+		}
+	}
+	for _, valIdx := range dpIdxSlice {
+		if dpSlice[valIdx] <= 9 {
+			return false
+		}
+	}
+	return true
+}
+
+// getMaskMap function will read mask bed file and return a map type, with
+// chromosome as key, and sorted merged 1-based closed intervals as value
+func getMaskMap(path string) (map[string][][]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^(#|track|browser)")
+	maskMap := map[string][][]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineSlice := strings.Fields(line)
+		if header.MatchString(line) || len(lineSlice) < 3 {
+			continue
+		}
+		startPos, err := strconv.Atoi(lineSlice[1])
+		if err != nil {
+			return nil, err
+		}
+		endPos, err := strconv.Atoi(lineSlice[2])
+		if err != nil {
+			return nil, err
+		}
+		maskMap[lineSlice[0]] = append(maskMap[lineSlice[0]], []int{startPos + 1, endPos})
+	}
+
+	for keyChr, valMask := range maskMap {
+		sort.Slice(valMask, func(a, b int) bool { return valMask[a][0] < valMask[b][0] })
+		mergedMask := [][]int{}
+		for _, valRegion := range valMask {
+			lastIdx := len(mergedMask) - 1
+			if lastIdx >= 0 && valRegion[0] <= mergedMask[lastIdx][1]+1 {
+				if valRegion[1] > mergedMask[lastIdx][1] {
+					mergedMask[lastIdx][1] = valRegion[1]
+				}
+			} else {
+				mergedMask = append(mergedMask, []int{valRegion[0], valRegion[1]})
+			}
+		}
+		maskMap[keyChr] = mergedMask
+	}
+	return maskMap, scanner.Err()
+}
+
+// isMasked function will check whether a position is inside masked regions
+func isMasked(maskMap map[string][][]int, chr string, pos int) bool {
+	chrMask := maskMap[chr]
+	maskIdx := sort.Search(len(chrMask), func(i int) bool { return chrMask[i][1] >= pos })
+	return maskIdx < len(chrMask) && chrMask[maskIdx][0] <= pos
+}
+
+// getDPcutoff function will calculate upper depth cutoff of each sample from
+// genome-wide depth distribution (sites with zero depth are ignored), using
+// the given quantile and/or mean + k*SD. The smaller cutoff is used if both
+// are given, and the cutoff is infinite if neither is given
+func getDPcutoff(dpLines [][]int, quantile float64, numSD float64) []float64 {
+	cutoffSlice := []float64{}
+	for idxSample := 0; idxSample < 4; idxSample++ {
+		sampleDpSlice := []int{}
+		for _, valDp := range dpLines {
+			if valDp[idxSample] > 0 {
+				sampleDpSlice = append(sampleDpSlice, valDp[idxSample])
+			}
+		}
+		cutoff := math.Inf(1)
+		numDp := len(sampleDpSlice)
+		if numDp == 0 {
+			cutoffSlice = append(cutoffSlice, cutoff)
+			continue
+		}
+		if quantile > 0.0 && quantile < 1.0 {
+			sort.Ints(sampleDpSlice)
+			quantileIdx := int(math.Ceil(quantile*float64(numDp))) - 1
+			if quantileIdx < 0 {
+				quantileIdx = 0
+			}
+			cutoff = math.Min(cutoff, float64(sampleDpSlice[quantileIdx]))
+		}
+		if numSD > 0.0 {
+			dpSum := 0.0
+			for _, valDp := range sampleDpSlice {
+				dpSum += float64(valDp)
+			}
+			dpMean := dpSum / float64(numDp)
+			dpVar := 0.0
+			for _, valDp := range sampleDpSlice {
+				dpVar += (float64(valDp) - dpMean) * (float64(valDp) - dpMean)
+			}
+			dpVar /= float64(numDp)
+			cutoff = math.Min(cutoff, dpMean+numSD*math.Sqrt(dpVar))
+		}
+		cutoffSlice = append(cutoffSlice, cutoff)
+	}
+	return cutoffSlice
+}
+
+// passDPcutoff function will check depth of all samples is not above upper
+// depth cutoffs, the order of depth slice is v3, MT, WT and CE
+func passDPcutoff(dpSlice []int, cutoffSlice []float64) bool {
+	for idxDp, valDp := range dpSlice {
+		if float64(valDp) > cutoffSlice[idxDp] {
+			return false
+		}
+	}
+	return true
+}
+
 // sortSMslice function will get all depth information for each alleles
 func sortSMslice(line string) string {
 	lineField := strings.Fields(line)
@@ -239,6 +593,13 @@ func main() {
 	flag.Parse()
 	fmt.Println("[", time.Now(), "] ", "Program start ...")
 
+	if *mode != "parents" && *mode != "single" && *mode != "bulk" {
+		log.Fatalf("unknown -mode %s: parents, single or bulk", *mode)
+	}
+	if *refPa != "v3" && *refPa != "CE" {
+		log.Fatalf("unknown -refParent %s: v3 or CE", *refPa)
+	}
+
 	//read header of vcf file
 	vcfHeader, err := getSNVheader(*vcfIn)
 	if err != nil {
@@ -253,6 +614,24 @@ func main() {
 
 	fmt.Println("Total number of vcf is: ", len(vcfLines))
 
+	//combine samples of each role into one sample
+	if *groupFile != "" {
+		vcfTitle, err := getSNVtitle(*vcfIn)
+		if err != nil {
+			log.Fatalf("read vcf title: %s", err)
+		}
+		groupCols, err := getGroupCols(*groupFile, vcfTitle, *mode, *refPa)
+		if err != nil {
+			log.Fatalf("read sample group file: %s", err)
+		}
+		for idxRole, valRole := range []string{"v3", "MT", "WT", "CE"} {
+			fmt.Println("Number of samples combined into ", valRole, " is: ", len(groupCols[idxRole]))
+		}
+		for idxLine, valLine := range vcfLines {
+			vcfLines[idxLine] = combineGroups(valLine, groupCols)
+		}
+	}
+
 	//put header into slice outVcf
 	var outVcf []string
 	for _, sHeader := range vcfHeader {
@@ -281,16 +660,60 @@ func main() {
 
 	fmt.Println("Total number of split VCF is: ", len(newVcfLines))
 
+	var maskMap map[string][][]int
+	if *maskFile != "" {
+		maskMap, err = getMaskMap(*maskFile)
+		if err != nil {
+			log.Fatalf("read mask bed file: %s", err)
+		}
+	}
+
+	//upper depth cutoff of each sample from genome-wide depth distribution
+	cutoffSlice := []float64{math.Inf(1), math.Inf(1), math.Inf(1), math.Inf(1)}
+	if *dpQuantile > 0.0 || *dpSD > 0.0 {
+		dpLines := [][]int{}
+		for _, line := range newVcfLines {
+			dpLines = append(dpLines, getDPslice(line))
+		}
+		cutoffSlice = getDPcutoff(dpLines, *dpQuantile, *dpSD)
+		for idxSample, valSample := range []string{"v3", "MT", "WT", "CE"} {
+			fmt.Println("Upper depth cutoff of ", valSample, " is: ", strconv.FormatFloat(cutoffSlice[idxSample], 'f', 2, 64))
+		}
+	}
+
 	i := 0
+	numMasked := 0
+	numHighDp := 0
 	for _, line := range newVcfLines {
+		if maskMap != nil {
+			lineField := strings.Fields(line)
+			linePos, _ := strconv.Atoi(lineField[1])
+			if isMasked(maskMap, lineField[0], linePos) {
+				numMasked++
+				continue
+			}
+		}
 		dpIntSlice := getDPslice(line)
-		if dpIntSlice[0] > 9 && dpIntSlice[1] > 9 && dpIntSlice[2] > 9 && dpIntSlice[3] > 9 {
+		if *ems && !passEMS(line, *emsPa) {
+			continue
+		}
+		if !passDPcutoff(dpIntSlice, cutoffSlice) {
+			numHighDp++
+			continue
+		}
+		if passDPslice(dpIntSlice, *mode, *refPa) {
 			newLine := sortSMslice(line)
 			outVcf = append(outVcf, newLine)
 			i++
 		}
 	}
 
+	if maskMap != nil {
+		fmt.Println("Total number of masked split VCF is: ", numMasked)
+	}
+	if *dpQuantile > 0.0 || *dpSD > 0.0 {
+		fmt.Println("Total number of split VCF above depth cutoffs is: ", numHighDp)
+	}
 	fmt.Println("Total number of passed split VCF is: ", i)
 
 	//write new vcf file
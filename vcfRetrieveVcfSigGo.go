@@ -160,8 +160,69 @@ func getSNVkey(path string) ([]string, error) {
 	return SNVkey, scanner.Err()
 }
 
+// getPASStitle function will read title line of pass file and return column
+// names into a slice type
+func getPASStitle(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^#ID")
+	scanner := bufio.NewScanner(file)
+	titleSlice := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			titleSlice = strings.Fields(line)
+			break
+		}
+	}
+	return titleSlice, scanner.Err()
+}
+
+// getExtraInfo function will make INFO fields of extra columns after the 19
+// fixed columns of pass file, column names are used as INFO IDs
+func getExtraInfo(lineSlice []string, passTitle []string) string {
+	var buffer bytes.Buffer
+	for idxExtra := 19; idxExtra < len(lineSlice) && idxExtra < len(passTitle); idxExtra++ {
+		buffer.WriteString(";")
+		buffer.WriteString(passTitle[idxExtra])
+		buffer.WriteString("=")
+		buffer.WriteString(lineSlice[idxExtra])
+	}
+	return buffer.String()
+}
+
+// getExtraInfoHeader function will make INFO header lines of extra columns
+// of pass file
+func getExtraInfoHeader(passTitle []string) []string {
+	infoDescMap := map[string]string{
+		"fisher_p":     "Two-sided Fisher exact test p-value of bulk allele counts",
+		"chisq_p":      "Chi-square test p-value of bulk allele counts",
+		"gtest_p":      "G-test p-value of bulk allele counts",
+		"post_WT":      "Posterior mean of alt allele frequency of WT bulk",
+		"post_WT_low":  "Lower bound of 95% credible interval of alt allele frequency of WT bulk",
+		"post_WT_high": "Upper bound of 95% credible interval of alt allele frequency of WT bulk",
+		"post_MT":      "Posterior mean of alt allele frequency of MT bulk",
+		"post_MT_low":  "Lower bound of 95% credible interval of alt allele frequency of MT bulk",
+		"post_MT_high": "Upper bound of 95% credible interval of alt allele frequency of MT bulk",
+		"post_pdiff":   "Posterior probability that allele frequencies of bulks differ",
+	}
+	extraHeader := []string{}
+	for idxExtra := 19; idxExtra < len(passTitle); idxExtra++ {
+		infoDesc, ok := infoDescMap[passTitle[idxExtra]]
+		if !ok {
+			infoDesc = passTitle[idxExtra] + " of pass file"
+		}
+		extraHeader = append(extraHeader, "##INFO=<ID="+passTitle[idxExtra]+",Number=1,Type=Float,Description=\""+infoDesc+"\">")
+	}
+	return extraHeader
+}
+
 // getPASSmap function will get pass infomation and put into a map
-func getPASSmap(path string) (map[string]string, error) {
+func getPASSmap(path string, passTitle []string) (map[string]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -200,6 +261,7 @@ func getPASSmap(path string) (map[string]string, error) {
 			buffer.WriteString(lineSlice[17])
 			buffer.WriteString(";P99H=")
 			buffer.WriteString(lineSlice[18])
+			buffer.WriteString(getExtraInfo(lineSlice, passTitle))
 
 			passMap[lineSlice[0]] = buffer.String()
 		}
@@ -208,7 +270,7 @@ func getPASSmap(path string) (map[string]string, error) {
 }
 
 // getSigPASSmap function will get pass infomation and put into a map
-func getSigPASSmap(path string) (map[string]string, error) {
+func getSigPASSmap(path string, passTitle []string) (map[string]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -254,6 +316,7 @@ func getSigPASSmap(path string) (map[string]string, error) {
 				buffer.WriteString(lineSlice[17])
 				buffer.WriteString(";P99H=")
 				buffer.WriteString(lineSlice[18])
+				buffer.WriteString(getExtraInfo(lineSlice, passTitle))
 
 				sigPassMap[lineSlice[0]] = buffer.String()
 			}
@@ -262,6 +325,28 @@ func getSigPASSmap(path string) (map[string]string, error) {
 	return sigPassMap, scanner.Err()
 }
 
+// getBINtitle function will read title line (#CHR) of sliding window file
+// and return column names as a slice type
+func getBINtitle(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^#CHR")
+	scanner := bufio.NewScanner(file)
+	titleSlice := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			titleSlice = strings.Fields(line)
+			break
+		}
+	}
+	return titleSlice, scanner.Err()
+}
+
 // getSigBINmap function will get significant slidng windows infomation and put into a slice
 func getSigBIN(path string) ([]string, error) {
 	file, err := os.Open(path)
@@ -383,15 +468,21 @@ func main() {
 	}
 	fmt.Println("[", time.Now(), "] ", "The total number of VCFs is: ", len(vcfKeyLines))
 
+	// get pass title
+	passTitle, err := getPASStitle(*passFile)
+	if err != nil {
+		log.Fatalf("read pass title: %s", err)
+	}
+
 	// get pass map
-	passMap, err := getPASSmap(*passFile)
+	passMap, err := getPASSmap(*passFile, passTitle)
 	if err != nil {
 		log.Fatalf("read pass map: %s", err)
 	}
 	fmt.Println("[", time.Now(), "] ", "The total number of pass VCFs is: ", len(passMap))
 
 	//get sig pass map
-	sigPassMap, err := getSigPASSmap(*passFile)
+	sigPassMap, err := getSigPASSmap(*passFile, passTitle)
 	if err != nil {
 		log.Fatalf("read sig pass map: %s", err)
 	}
@@ -436,11 +527,18 @@ func main() {
 		}
 	}
 
-	//write sig bins
-	headerBinSlice := []string{"#CHR", "START", "END", "mid_pos", "num_SNVs",
-		"AveIdx_v3", "AveIdx_ce", "AveIdx_wt", "AveIdx_mt",
-		"AveIdx_parent", "AveIdx_F2", "AveDp_wt", "AveDp_mt",
-		"Ave_p90L", "Ave_p90H", "Ave_p95L", "Ave_p95H", "Ave_p99L", "Ave_p99H",
+	//write sig bins with title of sliding window file, as optional columns
+	//vary between runs
+	headerBinSlice, err := getBINtitle(*binFile)
+	if err != nil {
+		log.Fatalf("read title of bin file: %s", err)
+	}
+	if len(headerBinSlice) == 0 {
+		headerBinSlice = []string{"#CHR", "START", "END", "mid_pos", "num_SNVs",
+			"AveIdx_v3", "AveIdx_ce", "AveIdx_wt", "AveIdx_mt",
+			"AveIdx_parent", "AveIdx_F2", "AveDp_wt", "AveDp_mt",
+			"Ave_p90L", "Ave_p90H", "Ave_p95L", "Ave_p95H", "Ave_p99L", "Ave_p99H",
+		}
 	}
 
 	newSigBinLines := []string{strings.Join(headerBinSlice, "\t")}
@@ -471,6 +569,7 @@ func main() {
 	//newVcfHeader = append(newVcfHeader, vcfHeaderMap["filter"])
 	newVcfHeader = append(newVcfHeader, vcfHeaderMap["format"])
 	newVcfHeader = append(newVcfHeader, newVcfHeaderInfo...)
+	newVcfHeader = append(newVcfHeader, getExtraInfoHeader(passTitle)...)
 	newVcfHeader = append(newVcfHeader, vcfHeaderMap["contig"])
 	newVcfHeader = append(newVcfHeader, vcfHeaderMap["reference"])
 
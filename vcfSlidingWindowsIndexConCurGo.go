@@ -6,9 +6,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"os"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +25,22 @@ var (
 	cpus       = flag.Int("c", 1, "Number of working CPUs")
 	windowSize = flag.Int("w", 20000000, "Window size, default (2mb)")
 	shiftSize  = flag.Int("s", 20000, "Shift size, default (20kb)")
+	windowSnv  = flag.Int("wSnv", 0, "Window size in number of SNVs, overrides -w and -s, default 0 (off)")
+	shiftSnv   = flag.Int("sSnv", 0, "Shift size in number of SNVs, default wSnv/10")
+	mapFile    = flag.String("map", "", "Input genetic map file (chr, position and cM in each line) to annotate windows in cM")
+	windowCM   = flag.Float64("wcM", 0.0, "Window size in cM, requires -map and overrides -w and -s, default 0 (off)")
+	shiftCM    = flag.Float64("scM", 0.0, "Shift size in cM, default wcM/10")
+	maskFile   = flag.String("mask", "", "Input bed file of masked regions, SNVs inside are removed")
+	maskMax    = flag.Float64("maskMax", 1.0, "Maximum masked fraction of a window, windows above are excluded, default 1 (keep all)")
+	minSNV     = flag.Int("minSNV", 10, "Minimum number of SNVs in a window to report averages, default 10")
+	robust     = flag.Bool("robust", false, "Report depth-weighted mean, median, SD, SE and trimmed mean of delta index (WAveIdx_F2 ... TrimIdx_F2)")
+	trimFrac   = flag.Float64("trim", 0.1, "Fraction of SNVs trimmed from each end for trimmed mean of delta index")
+	ems        = flag.Bool("ems", false, "Report number and density of EMS SNVs (G>A, C>T) in windows")
+	numBoot    = flag.Int("boot", 0, "Number of bootstrap replicates for confidence interval of QTL peaks, default 0 (off)")
+	qtlOut     = flag.String("qtlOut", "", "Output file with QTLs (consecutive windows with AveIdx_F2 > Ave_p90H) and peaks")
+	bootOut    = flag.String("bootOut", "", "Output file with bootstrap confidence interval of QTL peaks")
+	bootReads  = flag.Bool("bootReads", false, "Also resample read counts of each SNV in bootstrap")
+	bootSeed   = flag.Int64("seed", 1, "Random seed of bootstrap")
 )
 
 // getLines function reads lines from vcf file and return a slice,
@@ -70,6 +89,53 @@ func getSNVlong(path string) (map[string]string, error) {
 	return SNVlong, scanner.Err()
 }
 
+// getSNVtitle function will read title line of vcf file and return column
+// names as a slice type
+func getSNVtitle(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^#ID")
+	titleSlice := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			titleSlice = strings.Fields(line)
+			break
+		}
+	}
+	return titleSlice, scanner.Err()
+}
+
+// getEMSmap function will read from vcf file and return a map type, with
+// chromosome and position of canonical EMS transitions (G>A, C>T) as key
+func getEMSmap(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^[^#]")
+	emsMap := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			lineSlice := strings.Fields(line)
+			idSlice := strings.Split(lineSlice[0], "_")
+			if (idSlice[2] == "G" && idSlice[3] == "A") || (idSlice[2] == "C" && idSlice[3] == "T") {
+				emsMap[strings.Join(idSlice[:2], "_")] = true
+			}
+		}
+	}
+	return emsMap, scanner.Err()
+}
+
 // writeLines function will write lines in a slice in to out file
 func writeLines(SNVlong []string, path string) error {
 	file, err := os.Create(path)
@@ -119,8 +185,266 @@ func makeSlidingWindows(chrLine []string, windowSize int, shiftSize int) []strin
 	return slidingWindowSlice
 }
 
+// getGeneticMap function will read genetic map file and return a map type,
+// with chromosome as key, and position and cM sorted by position as value
+func getGeneticMap(path string) (map[int][][]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^[^#]")
+	geneticMap := map[int][][]float64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header.MatchString(line) {
+			lineSlice := strings.Fields(line)
+			if len(lineSlice) < 3 {
+				continue
+			}
+			chrPos, err := strconv.Atoi(lineSlice[0])
+			if err != nil {
+				return nil, err
+			}
+			mapPos, err := strconv.ParseFloat(lineSlice[1], 64)
+			if err != nil {
+				return nil, err
+			}
+			mapCM, err := strconv.ParseFloat(lineSlice[2], 64)
+			if err != nil {
+				return nil, err
+			}
+			geneticMap[chrPos] = append(geneticMap[chrPos], []float64{mapPos, mapCM})
+		}
+	}
+	//cM to bp interpolation needs cM not decreasing along chromosome
+	for keyMap, valMap := range geneticMap {
+		sort.Slice(valMap, func(a, b int) bool { return valMap[a][0] < valMap[b][0] })
+		for idxMarker := 1; idxMarker < len(valMap); idxMarker++ {
+			if valMap[idxMarker][1] < valMap[idxMarker-1][1] {
+				return nil, fmt.Errorf("cM decreases from %.0f to %.0f bp on chromosome %d", valMap[idxMarker-1][0], valMap[idxMarker][0], keyMap)
+			}
+		}
+	}
+	return geneticMap, scanner.Err()
+}
+
+// interpolateMap function will linearly interpolate between markers of a
+// genetic map sorted by fromIdx, converting position to cM (fromIdx 0) or
+// cM to position (fromIdx 1). Values outside the map are extrapolated with
+// the rate of the first or last interval
+func interpolateMap(chrMap [][]float64, val float64, fromIdx int) float64 {
+	toIdx := 1 - fromIdx
+	numMarker := len(chrMap)
+	if numMarker == 0 {
+		return 0.0
+	}
+	if numMarker == 1 {
+		return chrMap[0][toIdx]
+	}
+	upIdx := sort.Search(numMarker, func(i int) bool { return chrMap[i][fromIdx] >= val })
+	if upIdx == 0 {
+		upIdx = 1
+	}
+	if upIdx == numMarker {
+		upIdx = numMarker - 1
+	}
+	lowMarker := chrMap[upIdx-1]
+	upMarker := chrMap[upIdx]
+	if upMarker[fromIdx] == lowMarker[fromIdx] {
+		return lowMarker[toIdx]
+	}
+	weight := (val - lowMarker[fromIdx]) / (upMarker[fromIdx] - lowMarker[fromIdx])
+	return lowMarker[toIdx] + weight*(upMarker[toIdx]-lowMarker[toIdx])
+}
+
+// makeCMWindows function will make sliding windows with window size windowCM
+// and shift size shiftCM in cM, converted back to physical positions
+func makeCMWindows(chrLine []string, geneticMap map[int][][]float64, windowCM float64, shiftCM float64) []string {
+	slidingWindowSlice := []string{}
+	for _, valChrLine := range chrLine {
+		chrStrSlice := strings.Fields(valChrLine)
+		chrPos, _ := strconv.Atoi(chrStrSlice[0])
+		chrLen, _ := strconv.Atoi(chrStrSlice[1])
+		chrMap, ok := geneticMap[chrPos]
+		if !ok {
+			fmt.Println("Skiped chromosome without genetic map: ", chrPos)
+			continue
+		}
+		startCM := interpolateMap(chrMap, 1.0, 0)
+		endCM := interpolateMap(chrMap, float64(chrLen), 0)
+		for midCM := startCM; midCM <= endCM; midCM += shiftCM {
+			binIntSlice := []int{
+				chrPos,
+				int(math.Round(interpolateMap(chrMap, midCM-windowCM/2.0, 1))),
+				int(math.Round(interpolateMap(chrMap, midCM+windowCM/2.0, 1))),
+				int(math.Round(interpolateMap(chrMap, midCM, 1))),
+			}
+			for i := 1; i < len(binIntSlice); i++ {
+				if binIntSlice[i] < 1 {
+					binIntSlice[i] = 1
+				}
+				if binIntSlice[i] > chrLen {
+					binIntSlice[i] = chrLen
+				}
+			}
+			binStrSlice := []string{}
+			for _, valBinVal := range binIntSlice {
+				binStrSlice = append(binStrSlice, strconv.Itoa(valBinVal))
+			}
+			slidingWindowSlice = append(slidingWindowSlice, strings.Join(binStrSlice, "\t"))
+		}
+	}
+	fmt.Println("The total number of sliding windows (", windowCM, " cM + ", shiftCM, " cM) is: ", len(slidingWindowSlice))
+	return slidingWindowSlice
+}
+
+// getCMannotation function will get interpolated cM positions of physical
+// positions on a chromosome, NA if the chromosome is not in genetic map
+func getCMannotation(geneticMap map[int][][]float64, chrStr string, posSlice []string) []string {
+	cmSlice := []string{}
+	chrPos, _ := strconv.Atoi(chrStr)
+	chrMap, ok := geneticMap[chrPos]
+	for _, valPos := range posSlice {
+		cmPos := "NA"
+		if intPos, err := strconv.Atoi(valPos); ok && err == nil {
+			cmPos = strconv.FormatFloat(interpolateMap(chrMap, float64(intPos), 0), 'f', 3, 64)
+		}
+		cmSlice = append(cmSlice, cmPos)
+	}
+	return cmSlice
+}
+
+// getMaskMap function will read mask bed file and return a map type, with
+// chromosome as key, and sorted merged 1-based closed intervals as value
+func getMaskMap(path string) (map[string][][]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _ := regexp.Compile("^(#|track|browser)")
+	maskMap := map[string][][]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineSlice := strings.Fields(line)
+		if header.MatchString(line) || len(lineSlice) < 3 {
+			continue
+		}
+		startPos, err := strconv.Atoi(lineSlice[1])
+		if err != nil {
+			return nil, err
+		}
+		endPos, err := strconv.Atoi(lineSlice[2])
+		if err != nil {
+			return nil, err
+		}
+		maskMap[lineSlice[0]] = append(maskMap[lineSlice[0]], []int{startPos + 1, endPos})
+	}
+
+	for keyChr, valMask := range maskMap {
+		sort.Slice(valMask, func(a, b int) bool { return valMask[a][0] < valMask[b][0] })
+		mergedMask := [][]int{}
+		for _, valRegion := range valMask {
+			lastIdx := len(mergedMask) - 1
+			if lastIdx >= 0 && valRegion[0] <= mergedMask[lastIdx][1]+1 {
+				if valRegion[1] > mergedMask[lastIdx][1] {
+					mergedMask[lastIdx][1] = valRegion[1]
+				}
+			} else {
+				mergedMask = append(mergedMask, []int{valRegion[0], valRegion[1]})
+			}
+		}
+		maskMap[keyChr] = mergedMask
+	}
+	return maskMap, scanner.Err()
+}
+
+// isMasked function will check whether a position is inside masked regions
+func isMasked(maskMap map[string][][]int, chr string, pos int) bool {
+	chrMask := maskMap[chr]
+	maskIdx := sort.Search(len(chrMask), func(i int) bool { return chrMask[i][1] >= pos })
+	return maskIdx < len(chrMask) && chrMask[maskIdx][0] <= pos
+}
+
+// calculateMaskFrac function will calculate fraction of masked bases in a
+// window
+func calculateMaskFrac(maskMap map[string][][]int, binLine []int) float64 {
+	numMasked := 0
+	for _, valRegion := range maskMap[strconv.Itoa(binLine[0])] {
+		startPos := valRegion[0]
+		endPos := valRegion[1]
+		if startPos < binLine[1] {
+			startPos = binLine[1]
+		}
+		if endPos > binLine[2] {
+			endPos = binLine[2]
+		}
+		if endPos >= startPos {
+			numMasked += endPos - startPos + 1
+		}
+	}
+	return float64(numMasked) / float64(binLine[2]-binLine[1]+1)
+}
+
+// makeSnvWindows function will make sliding windows each with windowSnv
+// consecutive SNVs, shifted by shiftSnv SNVs. Window starts and ends at the
+// first and last SNV, and mid position is the middle of the span
+func makeSnvWindows(chrLine []string, vcfLine map[string]string, windowSnv int, shiftSnv int) []string {
+	chrPosMap := map[int][]int{}
+	for keyVcf := range vcfLine {
+		keySlice := strings.Split(keyVcf, "_")
+		chrPos, _ := strconv.Atoi(keySlice[0])
+		snvPos, _ := strconv.Atoi(keySlice[1])
+		chrPosMap[chrPos] = append(chrPosMap[chrPos], snvPos)
+	}
+
+	slidingWindowSlice := []string{}
+	for _, valChrLine := range chrLine {
+		chrPos, _ := strconv.Atoi(strings.Fields(valChrLine)[0])
+		posSlice := chrPosMap[chrPos]
+		sort.Ints(posSlice)
+		numPos := len(posSlice)
+		if numPos == 0 {
+			continue
+		}
+		startIdxSlice := []int{}
+		for i := 0; i+windowSnv <= numPos; i += shiftSnv {
+			startIdxSlice = append(startIdxSlice, i)
+		}
+		//last window ends at the last SNV of chromosome
+		if len(startIdxSlice) == 0 || startIdxSlice[len(startIdxSlice)-1]+windowSnv < numPos {
+			lastIdx := numPos - windowSnv
+			if lastIdx < 0 {
+				lastIdx = 0
+			}
+			startIdxSlice = append(startIdxSlice, lastIdx)
+		}
+		for _, valIdx := range startIdxSlice {
+			endIdx := valIdx + windowSnv - 1
+			if endIdx >= numPos {
+				endIdx = numPos - 1
+			}
+			startPos := posSlice[valIdx]
+			endPos := posSlice[endIdx]
+			binIntSlice := []int{chrPos, startPos, endPos, (startPos + endPos) / 2}
+			binStrSlice := []string{}
+			for _, valBinVal := range binIntSlice {
+				binStrSlice = append(binStrSlice, strconv.Itoa(valBinVal))
+			}
+			slidingWindowSlice = append(slidingWindowSlice, strings.Join(binStrSlice, "\t"))
+		}
+	}
+	fmt.Println("The total number of sliding windows (", windowSnv, " SNVs + ", shiftSnv, " SNVs) is: ", len(slidingWindowSlice))
+	return slidingWindowSlice
+}
+
 // calculateAveIndex function will calculate avergege index
-func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
+func calculateAveIndex(binLine []int, vcfLine map[string]string, minSNV int) string {
 	indexSlice := []string{}
 	v3IndexSum := 0.0
 	ceIndexSum := 0.0
@@ -194,7 +518,7 @@ func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
 	p99LAve := 0.0
 	p99HAve := 0.0
 
-	if numVcf > 9 {
+	if numVcf >= minSNV {
 		v3IndexAve = v3IndexSum / float64(numVcf)
 		ceIndexAve = ceIndexSum / float64(numVcf)
 		wtIndexAve = wtIndexSum / float64(numVcf)
@@ -235,8 +559,118 @@ func calculateAveIndex(binLine []int, vcfLine map[string]string) string {
 	return strings.Join(indexSlice, "\t")
 }
 
+// calculateRobustIndex function will calculate depth-weighted mean (weighted
+// by total depth of both bulks), median, standard deviation, standard error
+// and trimmed mean of delta index of F2 in a window
+func calculateRobustIndex(binLine []int, vcfLine map[string]string, minSNV int, trimFrac float64) string {
+	f2IndexSlice := []float64{}
+	f2WeightSum := 0.0
+	dpSum := 0.0
+	for j := binLine[1]; j <= binLine[2]; j++ {
+		posKey := strconv.Itoa(binLine[0]) + "_" + strconv.Itoa(j)
+		if posVal, ok := vcfLine[posKey]; ok {
+			posValSlice := strings.Fields(posVal)
+			f2VcfIndex, _ := strconv.ParseFloat(posValSlice[5], 64)
+			wtVcfDp, _ := strconv.ParseFloat(posValSlice[6], 64)
+			mtVcfDp, _ := strconv.ParseFloat(posValSlice[7], 64)
+			f2IndexSlice = append(f2IndexSlice, f2VcfIndex)
+			f2WeightSum += f2VcfIndex * (wtVcfDp + mtVcfDp)
+			dpSum += wtVcfDp + mtVcfDp
+		}
+	}
+
+	numVcf := len(f2IndexSlice)
+	wAveIndex := 0.0
+	medIndex := 0.0
+	sdIndex := 0.0
+	seIndex := 0.0
+	trimIndex := 0.0
+	if numVcf >= minSNV && numVcf > 1 {
+		if dpSum > 0.0 {
+			wAveIndex = f2WeightSum / dpSum
+		}
+		sort.Float64s(f2IndexSlice)
+		if numVcf%2 == 1 {
+			medIndex = f2IndexSlice[numVcf/2]
+		} else {
+			medIndex = (f2IndexSlice[numVcf/2-1] + f2IndexSlice[numVcf/2]) / 2.0
+		}
+
+		f2IndexSum := 0.0
+		for _, valIndex := range f2IndexSlice {
+			f2IndexSum += valIndex
+		}
+		f2IndexAve := f2IndexSum / float64(numVcf)
+		for _, valIndex := range f2IndexSlice {
+			sdIndex += (valIndex - f2IndexAve) * (valIndex - f2IndexAve)
+		}
+		sdIndex = math.Sqrt(sdIndex / float64(numVcf-1))
+		seIndex = sdIndex / math.Sqrt(float64(numVcf))
+
+		numTrim := int(trimFrac * float64(numVcf))
+		if 2*numTrim >= numVcf {
+			numTrim = (numVcf - 1) / 2
+		}
+		trimSum := 0.0
+		for _, valIndex := range f2IndexSlice[numTrim : numVcf-numTrim] {
+			trimSum += valIndex
+		}
+		trimIndex = trimSum / float64(numVcf-2*numTrim)
+	}
+
+	robustSlice := []string{}
+	for _, valStat := range []float64{wAveIndex, medIndex, sdIndex, seIndex, trimIndex} {
+		robustSlice = append(robustSlice, strconv.FormatFloat(valStat, 'f', 2, 64))
+	}
+	return strings.Join(robustSlice, "\t")
+}
+
+// calculateAvePost function will calculate average of posterior columns,
+// postCols are indices of the columns in index fields of vcf map
+func calculateAvePost(binLine []int, vcfLine map[string]string, postCols []int, minSNV int) string {
+	postSumSlice := make([]float64, len(postCols))
+	numVcf := 0
+	for j := binLine[1]; j <= binLine[2]; j++ {
+		posKey := strconv.Itoa(binLine[0]) + "_" + strconv.Itoa(j)
+		if posVal, ok := vcfLine[posKey]; ok {
+			posValSlice := strings.Fields(posVal)
+			for i, valCol := range postCols {
+				postVal, _ := strconv.ParseFloat(posValSlice[valCol], 64)
+				postSumSlice[i] += postVal
+			}
+			numVcf++
+		}
+	}
+
+	postAveSlice := []string{}
+	for _, valSum := range postSumSlice {
+		postAve := 0.0
+		if numVcf >= minSNV {
+			postAve = valSum / float64(numVcf)
+		}
+		postAveSlice = append(postAveSlice, strconv.FormatFloat(postAve, 'f', 3, 64))
+	}
+	return strings.Join(postAveSlice, "\t")
+}
+
+// calculateEMSdensity function will count EMS SNVs in a window and return
+// the count and the density per Mb
+func calculateEMSdensity(binLine []int, emsMap map[string]bool) string {
+	numEMS := 0
+	for j := binLine[1]; j <= binLine[2]; j++ {
+		posKey := strconv.Itoa(binLine[0]) + "_" + strconv.Itoa(j)
+		if emsMap[posKey] {
+			numEMS++
+		}
+	}
+	emsDensity := float64(numEMS) / float64(binLine[2]-binLine[1]+1) * 1000000.0
+
+	emsSlice := []string{strconv.Itoa(numEMS), strconv.FormatFloat(emsDensity, 'f', 2, 64)}
+	return strings.Join(emsSlice, "\t")
+}
+
 //worker function for making worker pools
-func worker(vcfLine map[string]string, jobs <-chan string, results chan<- string) {
+func worker(vcfLine map[string]string, emsMap map[string]bool, postCols []int, minSNV int, robust bool, trimFrac float64, spanCol bool, jobs <-chan string, results chan<- string) {
 	for j := range jobs {
 		binSlice := strings.Fields(j)
 		chrPos, _ := strconv.Atoi(binSlice[0])
@@ -245,7 +679,161 @@ func worker(vcfLine map[string]string, jobs <-chan string, results chan<- string
 		midPos, _ := strconv.Atoi(binSlice[3])
 		binPosSlice := []int{chrPos, startPos, endPos, midPos}
 
-		results <- calculateAveIndex(binPosSlice, vcfLine)
+		binIndexSlice := []string{calculateAveIndex(binPosSlice, vcfLine, minSNV)}
+		if robust {
+			binIndexSlice = append(binIndexSlice, calculateRobustIndex(binPosSlice, vcfLine, minSNV, trimFrac))
+		}
+		if spanCol {
+			binIndexSlice = append(binIndexSlice, strconv.Itoa(endPos-startPos+1))
+		}
+		if emsMap != nil {
+			binIndexSlice = append(binIndexSlice, calculateEMSdensity(binPosSlice, emsMap))
+		}
+		if len(postCols) > 0 {
+			binIndexSlice = append(binIndexSlice, calculateAvePost(binPosSlice, vcfLine, postCols, minSNV))
+		}
+
+		results <- strings.Join(binIndexSlice, "\t")
+	}
+}
+
+// getQTLpeaks function will merge consecutive significant windows (AveIdx_F2
+// > Ave_p90H, as in sig bins) into QTLs, and return chr, start, end, peak
+// position and peak AveIdx_F2 of each QTL
+func getQTLpeaks(binLines []string) [][]string {
+	qtlSlice := [][]string{}
+	var curQTL []string
+	peakIndex := 0.0
+	for _, valBin := range binLines {
+		binSlice := strings.Fields(valBin)
+		f2Index, _ := strconv.ParseFloat(binSlice[10], 64)
+		p90H, _ := strconv.ParseFloat(binSlice[14], 64)
+		isSig := f2Index > p90H
+		if curQTL != nil && (!isSig || binSlice[0] != curQTL[0]) {
+			qtlSlice = append(qtlSlice, curQTL)
+			curQTL = nil
+		}
+		if !isSig {
+			continue
+		}
+		if curQTL == nil {
+			curQTL = []string{binSlice[0], binSlice[1], binSlice[2], binSlice[3], binSlice[10]}
+			peakIndex = f2Index
+		}
+		curQTL[2] = binSlice[2]
+		if f2Index > peakIndex {
+			peakIndex = f2Index
+			curQTL[3] = binSlice[3]
+			curQTL[4] = binSlice[10]
+		}
+	}
+	if curQTL != nil {
+		qtlSlice = append(qtlSlice, curQTL)
+	}
+	return qtlSlice
+}
+
+// getQTLsnv function will get SNVs inside a QTL in order of position, each SNV
+// is stored as position, WT index, MT index, parent sign, WT depth, MT depth
+func getQTLsnv(qtlLine []string, vcfLine map[string]string) [][]float64 {
+	chrPos := qtlLine[0]
+	startPos, _ := strconv.Atoi(qtlLine[1])
+	endPos, _ := strconv.Atoi(qtlLine[2])
+	qtlSnvSlice := [][]float64{}
+	for j := startPos; j <= endPos; j++ {
+		if posVal, ok := vcfLine[chrPos+"_"+strconv.Itoa(j)]; ok {
+			posValSlice := strings.Fields(posVal)
+			snvFloat := []float64{float64(j)}
+			for _, idxVal := range []int{2, 3, 4, 6, 7} {
+				floatVal, _ := strconv.ParseFloat(posValSlice[idxVal], 64)
+				snvFloat = append(snvFloat, floatVal)
+			}
+			if snvFloat[3] < 0.0 {
+				snvFloat[3] = -1.0
+			} else {
+				snvFloat[3] = 1.0
+			}
+			qtlSnvSlice = append(qtlSnvSlice, snvFloat)
+		}
+	}
+	return qtlSnvSlice
+}
+
+// getQTLbins function will get sliding windows fully inside a QTL
+func getQTLbins(qtlLine []string, binSlice []string) [][]int {
+	startPos, _ := strconv.Atoi(qtlLine[1])
+	endPos, _ := strconv.Atoi(qtlLine[2])
+	qtlBinSlice := [][]int{}
+	for _, valBin := range binSlice {
+		binStrSlice := strings.Fields(valBin)
+		binStart, _ := strconv.Atoi(binStrSlice[1])
+		binEnd, _ := strconv.Atoi(binStrSlice[2])
+		binMid, _ := strconv.Atoi(binStrSlice[3])
+		if binStrSlice[0] == qtlLine[0] && binStart >= startPos && binEnd <= endPos {
+			qtlBinSlice = append(qtlBinSlice, []int{binStart, binEnd, binMid})
+		}
+	}
+	return qtlBinSlice
+}
+
+// resampleIndex function will draw read counts of a sample from binomial
+// distribution with given depth and index
+func resampleIndex(r *rand.Rand, dp float64, index float64) float64 {
+	if dp < 1.0 {
+		return index
+	}
+	altCount := 0
+	for i := 0; i < int(dp); i++ {
+		if r.Float64() < index {
+			altCount++
+		}
+	}
+	return float64(altCount) / float64(int(dp))
+}
+
+// bootPeak function will resample SNVs (and read counts) of a QTL with
+// replacement, recalculate average delta index of windows and return the mid
+// position of the window with the highest average
+func bootPeak(qtlSnvSlice [][]float64, qtlBinSlice [][]int, bootReads bool, minSNV int, seed int64) int {
+	r := rand.New(rand.NewSource(seed))
+	numSnv := len(qtlSnvSlice)
+	countSlice := make([]float64, numSnv)
+	f2Slice := make([]float64, numSnv)
+	for i := 0; i < numSnv; i++ {
+		countSlice[r.Intn(numSnv)]++
+	}
+	for i, valSnv := range qtlSnvSlice {
+		wtIndex := valSnv[1]
+		mtIndex := valSnv[2]
+		if bootReads && countSlice[i] > 0 {
+			wtIndex = resampleIndex(r, valSnv[4], wtIndex)
+			mtIndex = resampleIndex(r, valSnv[5], mtIndex)
+		}
+		f2Slice[i] = (wtIndex - mtIndex) * valSnv[3]
+	}
+
+	peakPos := -1
+	peakIndex := 0.0
+	for _, valBin := range qtlBinSlice {
+		startIdx := sort.Search(numSnv, func(i int) bool { return qtlSnvSlice[i][0] >= float64(valBin[0]) })
+		f2Sum := 0.0
+		numVcf := 0.0
+		for i := startIdx; i < numSnv && qtlSnvSlice[i][0] <= float64(valBin[1]); i++ {
+			f2Sum += countSlice[i] * f2Slice[i]
+			numVcf += countSlice[i]
+		}
+		if numVcf >= float64(minSNV) && (peakPos < 0 || f2Sum/numVcf > peakIndex) {
+			peakIndex = f2Sum / numVcf
+			peakPos = valBin[2]
+		}
+	}
+	return peakPos
+}
+
+//bootWorker function for making worker pools of bootstrap replicates
+func bootWorker(qtlSnvSlice [][]float64, qtlBinSlice [][]int, bootReads bool, minSNV int, seed int64, jobs <-chan int, results chan<- int) {
+	for j := range jobs {
+		results <- bootPeak(qtlSnvSlice, qtlBinSlice, bootReads, minSNV, seed+int64(j))
 	}
 }
 
@@ -273,19 +861,114 @@ func main() {
 		log.Fatalf("read input vcf file: %s", err)
 	}
 
+	//posterior columns of calAF are averaged by name
+	vcfTitle, err := getSNVtitle(*vcfFile)
+	if err != nil {
+		log.Fatalf("read title of input vcf file: %s", err)
+	}
+	postCols := []int{}
+	postHeader := []string{}
+	for idxTitle, valTitle := range vcfTitle {
+		if strings.HasPrefix(valTitle, "post_") && idxTitle >= 5 {
+			postCols = append(postCols, idxTitle-5)
+			postHeader = append(postHeader, "Ave_"+valTitle)
+		}
+	}
+
+	var emsMap map[string]bool
+	if *ems {
+		emsMap, err = getEMSmap(*vcfFile)
+		if err != nil {
+			log.Fatalf("read EMS SNVs from vcf file: %s", err)
+		}
+		fmt.Println("Total number of EMS SNVs is: ", len(emsMap))
+	}
+
 	chrLines, err := getLines(*chrFile)
 	if err != nil {
 		log.Fatalf("read input chr file: %s", err)
 	}
 
+	var maskMap map[string][][]int
+	if *maskFile != "" {
+		maskMap, err = getMaskMap(*maskFile)
+		if err != nil {
+			log.Fatalf("read mask bed file: %s", err)
+		}
+		numMasked := 0
+		for keyVcf := range vcfLines {
+			keySlice := strings.Split(keyVcf, "_")
+			keyPos, _ := strconv.Atoi(keySlice[1])
+			if isMasked(maskMap, keySlice[0], keyPos) {
+				delete(vcfLines, keyVcf)
+				numMasked++
+			}
+		}
+		fmt.Println("Total number of masked SNVs is: ", numMasked)
+		for keyEMS := range emsMap {
+			keySlice := strings.Split(keyEMS, "_")
+			keyPos, _ := strconv.Atoi(keySlice[1])
+			if isMasked(maskMap, keySlice[0], keyPos) {
+				delete(emsMap, keyEMS)
+			}
+		}
+	}
+
+	var geneticMap map[int][][]float64
+	if *mapFile != "" {
+		geneticMap, err = getGeneticMap(*mapFile)
+		if err != nil {
+			log.Fatalf("read genetic map file: %s", err)
+		}
+		fmt.Println("Total number of chromosomes in genetic map is: ", len(geneticMap))
+	}
+
 	binSlice := []string{}
-	binSlice = makeSlidingWindows(chrLines, *windowSize, *shiftSize)
+	if *windowCM > 0.0 {
+		if geneticMap == nil {
+			log.Fatalf("window size in cM requires genetic map file (-map)")
+		}
+		if *shiftCM <= 0.0 {
+			*shiftCM = *windowCM / 10.0
+		}
+		binSlice = makeCMWindows(chrLines, geneticMap, *windowCM, *shiftCM)
+	} else if *windowSnv > 0 {
+		if *shiftSnv <= 0 {
+			*shiftSnv = *windowSnv / 10
+			if *shiftSnv < 1 {
+				*shiftSnv = 1
+			}
+		}
+		binSlice = makeSnvWindows(chrLines, vcfLines, *windowSnv, *shiftSnv)
+	} else {
+		binSlice = makeSlidingWindows(chrLines, *windowSize, *shiftSize)
+	}
+
+	//masked fraction of windows, windows above maskMax are excluded
+	maskFracMap := map[string]string{}
+	if maskMap != nil {
+		keepBinSlice := []string{}
+		for _, valBinSlice := range binSlice {
+			binIntSlice := []int{}
+			for _, valBin := range strings.Fields(valBinSlice) {
+				intBin, _ := strconv.Atoi(valBin)
+				binIntSlice = append(binIntSlice, intBin)
+			}
+			maskFrac := calculateMaskFrac(maskMap, binIntSlice)
+			if maskFrac <= *maskMax {
+				keepBinSlice = append(keepBinSlice, valBinSlice)
+				maskFracMap[valBinSlice] = strconv.FormatFloat(maskFrac, 'f', 3, 64)
+			}
+		}
+		fmt.Println("Total number of windows excluded by masked fraction is: ", len(binSlice)-len(keepBinSlice))
+		binSlice = keepBinSlice
+	}
 
 	//start worker
 	jobs := make(chan string, len(binSlice))
 	results := make(chan string, len(binSlice))
 	for w := 1; w <= numThreads; w++ {
-		go worker(vcfLines, jobs, results)
+		go worker(vcfLines, emsMap, postCols, *minSNV, *robust, *trimFrac, *windowSnv > 0, jobs, results)
 	}
 
 	for _, valBinSlice := range binSlice {
@@ -310,11 +993,36 @@ func main() {
 		"AveIdx_parent", "AveIdx_F2", "AveDp_wt", "AveDp_mt",
 		"Ave_p90L", "Ave_p90H", "Ave_p95L", "Ave_p95H", "Ave_p99L", "Ave_p99H",
 	}
+	if *robust {
+		binHeader = append(binHeader, "WAveIdx_F2", "MedIdx_F2", "SdIdx_F2", "SeIdx_F2", "TrimIdx_F2")
+	}
+	if *windowSnv > 0 {
+		binHeader = append(binHeader, "span_bp")
+	}
+	if *ems {
+		binHeader = append(binHeader, "num_EMS", "EMS_per_Mb")
+	}
+	binHeader = append(binHeader, postHeader...)
+	if geneticMap != nil {
+		binHeader = append(binHeader, "START_cM", "END_cM", "mid_cM")
+	}
+	if maskMap != nil {
+		binHeader = append(binHeader, "masked_frac")
+	}
 
 	newBinLines := []string{}
 	newBinLines = append(newBinLines, strings.Join(binHeader, "\t"))
 	for _, valBinSlice := range binSlice {
-		newBinLines = append(newBinLines, mapBinLines[valBinSlice])
+		newBinLine := mapBinLines[valBinSlice]
+		if geneticMap != nil {
+			binStrSlice := strings.Fields(valBinSlice)
+			cmSlice := getCMannotation(geneticMap, binStrSlice[0], binStrSlice[1:4])
+			newBinLine += "\t" + strings.Join(cmSlice, "\t")
+		}
+		if maskMap != nil {
+			newBinLine += "\t" + maskFracMap[valBinSlice]
+		}
+		newBinLines = append(newBinLines, newBinLine)
 	}
 
 	//write skip snv file
@@ -322,5 +1030,82 @@ func main() {
 		log.Fatalf("write sliding windows: $s", err)
 	}
 
+	//QTLs and peaks from sliding windows
+	var qtlSlice [][]string
+	if *qtlOut != "" || *numBoot > 0 {
+		qtlSlice = getQTLpeaks(newBinLines[1:])
+		fmt.Println("Total number of QTLs is: ", len(qtlSlice))
+	}
+	if *qtlOut != "" {
+		qtlHeader := []string{"#CHR", "START", "END", "peak_pos", "peak_AveIdx_F2"}
+		if geneticMap != nil {
+			qtlHeader = append(qtlHeader, "START_cM", "END_cM", "peak_cM")
+		}
+		qtlLines := []string{strings.Join(qtlHeader, "\t")}
+		for _, valQTL := range qtlSlice {
+			qtlLine := append([]string{}, valQTL...)
+			if geneticMap != nil {
+				qtlLine = append(qtlLine, getCMannotation(geneticMap, valQTL[0], valQTL[1:4])...)
+			}
+			qtlLines = append(qtlLines, strings.Join(qtlLine, "\t"))
+		}
+		if err := writeLines(qtlLines, *qtlOut); err != nil {
+			log.Fatalf("write QTLs: %s", err)
+		}
+	}
+
+	//bootstrap confidence interval of QTL peaks
+	if *numBoot > 0 {
+		bootHeader := []string{"#CHR", "START", "END", "peak_pos", "peak_AveIdx_F2", "CI90_low", "CI90_high", "CI95_low", "CI95_high"}
+		if geneticMap != nil {
+			bootHeader = append(bootHeader, "START_cM", "END_cM", "peak_cM", "CI95_low_cM", "CI95_high_cM")
+		}
+		bootLines := []string{strings.Join(bootHeader, "\t")}
+		for idxQTL, valQTL := range qtlSlice {
+			qtlSnvSlice := getQTLsnv(valQTL, vcfLines)
+			qtlBinSlice := getQTLbins(valQTL, binSlice)
+
+			bootJobs := make(chan int, *numBoot)
+			bootResults := make(chan int, *numBoot)
+			for w := 1; w <= numThreads; w++ {
+				go bootWorker(qtlSnvSlice, qtlBinSlice, *bootReads, *minSNV, *bootSeed+int64(idxQTL**numBoot), bootJobs, bootResults)
+			}
+			for b := 0; b < *numBoot; b++ {
+				bootJobs <- b
+			}
+			close(bootJobs)
+
+			peakSlice := []int{}
+			for b := 1; b <= *numBoot; b++ {
+				if peakPos := <-bootResults; peakPos >= 0 {
+					peakSlice = append(peakSlice, peakPos)
+				}
+			}
+			sort.Ints(peakSlice)
+
+			bootSlice := append([]string{}, valQTL...)
+			for _, valProb := range []float64{0.05, 0.95, 0.025, 0.975} {
+				ciPos := "NA"
+				if len(peakSlice) > 0 {
+					ciIdx := int(valProb * float64(len(peakSlice)))
+					if ciIdx >= len(peakSlice) {
+						ciIdx = len(peakSlice) - 1
+					}
+					ciPos = strconv.Itoa(peakSlice[ciIdx])
+				}
+				bootSlice = append(bootSlice, ciPos)
+			}
+			if geneticMap != nil {
+				cmPosSlice := []string{bootSlice[1], bootSlice[2], bootSlice[3], bootSlice[7], bootSlice[8]}
+				bootSlice = append(bootSlice, getCMannotation(geneticMap, bootSlice[0], cmPosSlice)...)
+			}
+			bootLines = append(bootLines, strings.Join(bootSlice, "\t"))
+		}
+
+		if err := writeLines(bootLines, *bootOut); err != nil {
+			log.Fatalf("write bootstrap confidence interval: %s", err)
+		}
+	}
+
 	fmt.Println("[", time.Now(), "] ", "Program end ...")
 }
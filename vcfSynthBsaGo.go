@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leesper/go_rng"
+)
+
+// declare command arguments
+var (
+	chrFile   = flag.String("chr", "", "Input chromosome length file (chr and length in each line)")
+	outFile   = flag.String("out", "", "Output raw vcf file in the layout of vcfPreProcess (v3, MT, WT, CE)")
+	truthFile = flag.String("truth", "", "Output truth bed file of planted QTLs")
+	popStruct = flag.String("p", "F2", "Population struction: F2, RIL or BC (backcross to v3)")
+	qtlList   = flag.String("qtl", "", "Comma separated planted QTLs as chr:pos:effect, effect is per CE allele")
+	numPop    = flag.Int("pop", 1000, "Number of individuals in the population")
+	numBulk   = flag.Int("bulk", 50, "Number of individuals in each bulk")
+	herit     = flag.Float64("h2", 0.5, "Heritability of the phenotype")
+	bulkDp    = flag.Float64("dp", 30.0, "Mean sequencing depth of each bulk")
+	parentDp  = flag.Float64("pdp", 20.0, "Mean sequencing depth of each parent")
+	snvDens   = flag.Float64("snv", 500.0, "Number of SNVs between parents per Mb")
+	cmPerMb   = flag.Float64("cmPerMb", 4.0, "Recombination rate in cM per Mb")
+	errRate   = flag.Float64("e", 0.0, "Per-base sequencing error rate, default 0")
+	seed      = flag.Int64("seed", 1, "Random seed")
+)
+
+// qtlInfo type stores position and effect of a planted QTL
+type qtlInfo struct {
+	chr    string
+	pos    int
+	effect float64
+}
+
+// getChrLen function will read chromosome length file
+func getChrLen(path string) ([]string, map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	chrSlice := []string{}
+	chrLenMap := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSlice := strings.Fields(scanner.Text())
+		if len(lineSlice) < 2 {
+			continue
+		}
+		chrLen, err := strconv.Atoi(lineSlice[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		chrSlice = append(chrSlice, lineSlice[0])
+		chrLenMap[lineSlice[0]] = chrLen
+	}
+	return chrSlice, chrLenMap, scanner.Err()
+}
+
+// getQTLs function will parse planted QTLs given as chr:pos:effect
+func getQTLs(qtlList string) ([]qtlInfo, error) {
+	qtlSlice := []qtlInfo{}
+	if qtlList == "" {
+		return qtlSlice, nil
+	}
+	for _, valQTL := range strings.Split(qtlList, ",") {
+		qtlElts := strings.Split(valQTL, ":")
+		if len(qtlElts) != 3 {
+			return nil, fmt.Errorf("QTL %s is not chr:pos:effect", valQTL)
+		}
+		qtlPos, err := strconv.Atoi(qtlElts[1])
+		if err != nil {
+			return nil, err
+		}
+		qtlEffect, err := strconv.ParseFloat(qtlElts[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		qtlSlice = append(qtlSlice, qtlInfo{qtlElts[0], qtlPos, qtlEffect})
+	}
+	return qtlSlice, nil
+}
+
+// makeGamete function will make a recombinant F1 gamete of a chromosome,
+// crossovers are drawn from a Poisson process (Haldane map function). The
+// gamete is stored as its first allele (0 v3, 1 CE) followed by crossover
+// positions
+func makeGamete(r *rand.Rand, chrLen int, cmPerMb float64) []int {
+	gamete := []int{r.Intn(2)}
+	lenMorgan := float64(chrLen) / 1000000.0 * cmPerMb / 100.0
+	pos := r.ExpFloat64() / lenMorgan * float64(chrLen)
+	for pos < float64(chrLen) {
+		gamete = append(gamete, int(pos))
+		pos += r.ExpFloat64() / lenMorgan * float64(chrLen)
+	}
+	return gamete
+}
+
+// getAllele function will get the allele of a gamete at the given position
+func getAllele(gamete []int, pos int) int {
+	if len(gamete) == 1 && gamete[0] < 0 {
+		return 0
+	}
+	numCross := sort.SearchInts(gamete[1:], pos)
+	return (gamete[0] + numCross) % 2
+}
+
+// makeIndvl function will make two gametes of each chromosome of an individual
+// given population struction. RIL is homozygous with map expanded 2 times by
+// selfing, BC carries one v3 gamete (stored as -1)
+func makeIndvl(r *rand.Rand, popStrut string, chrSlice []string, chrLenMap map[string]int, cmPerMb float64) map[string][][]int {
+	indvlMap := map[string][][]int{}
+	for _, valChr := range chrSlice {
+		switch popStrut {
+		case "RIL":
+			gamete := makeGamete(r, chrLenMap[valChr], 2.0*cmPerMb)
+			indvlMap[valChr] = [][]int{gamete, gamete}
+		case "BC":
+			indvlMap[valChr] = [][]int{makeGamete(r, chrLenMap[valChr], cmPerMb), {-1}}
+		default:
+			indvlMap[valChr] = [][]int{makeGamete(r, chrLenMap[valChr], cmPerMb), makeGamete(r, chrLenMap[valChr], cmPerMb)}
+		}
+	}
+	return indvlMap
+}
+
+// getDosage function will get number of CE alleles of an individual
+func getDosage(indvl map[string][][]int, chr string, pos int) int {
+	return getAllele(indvl[chr][0], pos) + getAllele(indvl[chr][1], pos)
+}
+
+// makeGeno function will make geno field of a sample with given depth and
+// alt allele ratio, in the format GT:DP:AD:RO:QR:AO:QA:GL
+func makeGeno(dp int, altDp int) string {
+	if dp == 0 {
+		return "."
+	}
+	refDp := dp - altDp
+	gt := "0/1"
+	switch {
+	case altDp == 0:
+		gt = "0/0"
+	case refDp == 0:
+		gt = "1/1"
+	}
+	genoSlice := []string{
+		gt, strconv.Itoa(dp), strconv.Itoa(refDp) + "," + strconv.Itoa(altDp),
+		strconv.Itoa(refDp), strconv.Itoa(refDp * 30), strconv.Itoa(altDp),
+		strconv.Itoa(altDp * 30), "0,0,0",
+	}
+	return strings.Join(genoSlice, ":")
+}
+
+// sampleReads function will draw depth from Poisson distribution and alt reads
+// from binomial distribution with alt allele ratio shifted by sequencing error
+func sampleReads(poissonge *rng.PoissonGenerator, binomalge *rng.BinomialGenerator, meanDp float64, altRatio float64, errRate float64) string {
+	dp := poissonge.Poisson(meanDp)
+	readRatio := altRatio*(1.0-errRate) + (1.0-altRatio)*errRate
+	altDp := int64(0)
+	if dp > 0 {
+		altDp = binomalge.Binomial(dp, readRatio)
+	}
+	return makeGeno(int(dp), int(altDp))
+}
+
+//function of write files
+func writeLines(lines []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// main function
+func main() {
+	flag.Parse()
+
+	fmt.Println("[", time.Now(), "] ", "Program start ...")
+
+	chrSlice, chrLenMap, err := getChrLen(*chrFile)
+	if err != nil {
+		log.Fatalf("read chromosome length file: %s", err)
+	}
+	qtlSlice, err := getQTLs(*qtlList)
+	if err != nil {
+		log.Fatalf("parse planted QTLs: %s", err)
+	}
+	for _, valQTL := range qtlSlice {
+		chrLen, ok := chrLenMap[valQTL.chr]
+		if !ok {
+			log.Fatalf("QTL chromosome %s is not in chromosome length file", valQTL.chr)
+		}
+		if valQTL.pos < 1 || valQTL.pos > chrLen {
+			log.Fatalf("QTL position %d is outside chromosome %s of length %d", valQTL.pos, valQTL.chr, chrLen)
+		}
+	}
+	if 2*(*numBulk) > *numPop {
+		log.Fatalf("two bulks of %d individuals exceed population of %d", *numBulk, *numPop)
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	poissonge := rng.NewPoissonGenerator(*seed)
+	binomalge := rng.NewBinomialGenerator(*seed)
+
+	//make population and genetic values
+	popSlice := []map[string][][]int{}
+	genoValSlice := []float64{}
+	for i := 0; i < *numPop; i++ {
+		indvl := makeIndvl(r, *popStruct, chrSlice, chrLenMap, *cmPerMb)
+		genoVal := 0.0
+		for _, valQTL := range qtlSlice {
+			genoVal += valQTL.effect * float64(getDosage(indvl, valQTL.chr, valQTL.pos))
+		}
+		popSlice = append(popSlice, indvl)
+		genoValSlice = append(genoValSlice, genoVal)
+	}
+
+	//phenotype with environmental variance given heritability
+	genoMean := 0.0
+	for _, valGeno := range genoValSlice {
+		genoMean += valGeno
+	}
+	genoMean /= float64(*numPop)
+	genoVar := 0.0
+	for _, valGeno := range genoValSlice {
+		genoVar += (valGeno - genoMean) * (valGeno - genoMean)
+	}
+	genoVar /= float64(*numPop)
+	envSd := 1.0
+	if genoVar > 0.0 && *herit > 0.0 && *herit < 1.0 {
+		envSd = math.Sqrt(genoVar * (1.0 - *herit) / *herit)
+	} else if *herit >= 1.0 {
+		envSd = 0.0
+	}
+	fmt.Println("Genetic variance and environmental SD is: ", genoVar, envSd)
+
+	phenoIdx := make([]int, *numPop)
+	phenoSlice := make([]float64, *numPop)
+	for i := range phenoSlice {
+		phenoIdx[i] = i
+		phenoSlice[i] = genoValSlice[i] + envSd*r.NormFloat64()
+	}
+	sort.Slice(phenoIdx, func(a, b int) bool { return phenoSlice[phenoIdx[a]] > phenoSlice[phenoIdx[b]] })
+
+	//high bulk is WT and low bulk is MT
+	highBulk := []map[string][][]int{}
+	lowBulk := []map[string][][]int{}
+	for i := 0; i < *numBulk; i++ {
+		highBulk = append(highBulk, popSlice[phenoIdx[i]])
+		lowBulk = append(lowBulk, popSlice[phenoIdx[*numPop-1-i]])
+	}
+
+	//bulk frequency of CE allele
+	getBulkFreq := func(bulk []map[string][][]int, chr string, pos int) float64 {
+		dosage := 0
+		for _, indvl := range bulk {
+			dosage += getDosage(indvl, chr, pos)
+		}
+		return float64(dosage) / float64(2*len(bulk))
+	}
+
+	vcfLines := []string{"##fileformat=VCFv4.2"}
+	for _, valChr := range chrSlice {
+		vcfLines = append(vcfLines, "##contig=<ID="+valChr+",length="+strconv.Itoa(chrLenMap[valChr])+">")
+	}
+	vcfLines = append(vcfLines, strings.Join([]string{
+		"#CHROM", "POS", "ID", "REF", "ALT", "QUAL",
+		"FILTER", "INFO", "FORMAT", "v3", "MT", "WT", "CE",
+	}, "\t"))
+
+	baseSlice := []string{"A", "C", "G", "T"}
+	numSnv := 0
+	for _, valChr := range chrSlice {
+		chrLen := chrLenMap[valChr]
+		pos := 0
+		for {
+			pos += 1 + int(r.ExpFloat64()*1000000.0 / *snvDens)
+			if pos > chrLen {
+				break
+			}
+			refIdx := r.Intn(4)
+			altIdx := (refIdx + 1 + r.Intn(3)) % 4
+
+			//ALT allele is carried by CE or v3 with equal chance
+			altInCE := r.Intn(2) == 0
+			wtFreq := getBulkFreq(highBulk, valChr, pos)
+			mtFreq := getBulkFreq(lowBulk, valChr, pos)
+			v3Alt := 0.0
+			ceAlt := 1.0
+			if !altInCE {
+				wtFreq = 1.0 - wtFreq
+				mtFreq = 1.0 - mtFreq
+				v3Alt = 1.0
+				ceAlt = 0.0
+			}
+
+			vcfSlice := []string{
+				valChr, strconv.Itoa(pos), ".", baseSlice[refIdx], baseSlice[altIdx],
+				"100", ".", "DP=100;AF=0.5", "GT:DP:AD:RO:QR:AO:QA:GL",
+				sampleReads(poissonge, binomalge, *parentDp, v3Alt, *errRate),
+				sampleReads(poissonge, binomalge, *bulkDp, mtFreq, *errRate),
+				sampleReads(poissonge, binomalge, *bulkDp, wtFreq, *errRate),
+				sampleReads(poissonge, binomalge, *parentDp, ceAlt, *errRate),
+			}
+			vcfLines = append(vcfLines, strings.Join(vcfSlice, "\t"))
+			numSnv++
+		}
+	}
+	fmt.Println("Total number of simulated SNVs is: ", numSnv)
+
+	if err := writeLines(vcfLines, *outFile); err != nil {
+		log.Fatalf("write simulated vcf file: %s", err)
+	}
+
+	//truth bed with realized CE allele frequency difference between bulks
+	truthLines := []string{"#CHR\tSTART\tEND\tNAME\teffect\tdeltaAF_CE"}
+	for idxQTL, valQTL := range qtlSlice {
+		deltaAF := getBulkFreq(highBulk, valQTL.chr, valQTL.pos) - getBulkFreq(lowBulk, valQTL.chr, valQTL.pos)
+		truthSlice := []string{
+			valQTL.chr, strconv.Itoa(valQTL.pos - 1), strconv.Itoa(valQTL.pos),
+			"QTL" + strconv.Itoa(idxQTL+1), strconv.FormatFloat(valQTL.effect, 'f', -1, 64),
+			strconv.FormatFloat(deltaAF, 'f', 4, 64),
+		}
+		truthLines = append(truthLines, strings.Join(truthSlice, "\t"))
+	}
+	if *truthFile != "" {
+		if err := writeLines(truthLines, *truthFile); err != nil {
+			log.Fatalf("write truth bed file: %s", err)
+		}
+	}
+
+	fmt.Println("[", time.Now(), "] ", "Program end ...")
+}